@@ -0,0 +1,149 @@
+package commandeer
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ByteSize is a number of bytes which can be set from text like "512KiB",
+// "1.5GB", or "10M". Suffixes follow GNU coreutils: "KB", "MB", etc. are powers
+// of 1000, while "KiB", "MiB", etc. and the single letters "K", "M", etc. are
+// powers of 1024. Suffixes aren't case sensitive, and a number without one is
+// in bytes. Use the unit:"bytes" tag to get the same behavior for an integer
+// field.
+type ByteSize uint64
+
+// Set parses s as a size.
+func (b *ByteSize) Set(s string) error {
+	n, err := parseByteSize(s)
+	if err != nil {
+		return err
+	}
+	*b = ByteSize(n)
+	return nil
+}
+
+// String formats the size with the largest unit it can be written in exactly
+// (to two decimal places), e.g. "512KiB" or "1.5GB".
+func (b ByteSize) String() string { return formatByteSize(uint64(b)) }
+
+// Type names the type in usage output.
+func (b *ByteSize) Type() string { return "size" }
+
+type byteUnit struct {
+	suffix string
+	size   uint64
+}
+
+// byteUnits are ordered from largest to smallest, so formatting uses the
+// biggest unit which fits.
+var byteUnits = []byteUnit{
+	{"EiB", 1 << 60}, {"EB", 1e18}, {"PiB", 1 << 50}, {"PB", 1e15},
+	{"TiB", 1 << 40}, {"TB", 1e12}, {"GiB", 1 << 30}, {"GB", 1e9},
+	{"MiB", 1 << 20}, {"MB", 1e6}, {"KiB", 1 << 10}, {"KB", 1e3},
+}
+
+// unitSize gets the number of bytes for a suffix.
+func unitSize(suffix string) (uint64, bool) {
+	suffix = strings.ToUpper(suffix)
+	switch suffix {
+	case "", "B":
+		return 1, true
+	case "K", "M", "G", "T", "P", "E":
+		suffix += "IB"
+	}
+	for _, unit := range byteUnits {
+		if strings.ToUpper(unit.suffix) == suffix {
+			return unit.size, true
+		}
+	}
+	return 0, false
+}
+
+func parseByteSize(s string) (uint64, error) {
+	s = strings.TrimSpace(s)
+	num := strings.TrimRightFunc(s, func(r rune) bool { return r == ' ' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') })
+	suffix := strings.TrimSpace(s[len(num):])
+	size, ok := unitSize(suffix)
+	if num == "" || !ok {
+		return 0, fmt.Errorf("invalid size '%s': expected a number of bytes, optionally followed by a unit like KB, KiB, or K", s)
+	}
+	if !strings.ContainsAny(num, ".eE") {
+		n, err := strconv.ParseUint(num, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid size '%s': %v", s, numError(err))
+		}
+		hi, lo := bits.Mul64(n, size)
+		if hi != 0 {
+			return 0, fmt.Errorf("invalid size '%s': %v", s, strconv.ErrRange)
+		}
+		return lo, nil
+	}
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("invalid size '%s': %v", s, strconv.ErrSyntax)
+	}
+	f = math.Round(f * float64(size))
+	if f >= math.MaxUint64 {
+		return 0, fmt.Errorf("invalid size '%s': %v", s, strconv.ErrRange)
+	}
+	return uint64(f), nil
+}
+
+func formatByteSize(n uint64) string {
+	for _, unit := range byteUnits {
+		if n < unit.size {
+			continue
+		}
+		hundredths := float64(n) / float64(unit.size) * 100
+		if hundredths == math.Trunc(hundredths) {
+			return strconv.FormatFloat(hundredths/100, 'f', -1, 64) + unit.suffix
+		}
+	}
+	return strconv.FormatUint(n, 10) + "B"
+}
+
+// bytesValue is the Value for an integer field with the unit:"bytes" tag.
+type bytesValue struct {
+	value reflect.Value
+}
+
+func (v bytesValue) Set(s string) error {
+	n, err := parseByteSize(s)
+	if err != nil {
+		return err
+	}
+	switch v.value.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if v.value.OverflowUint(n) {
+			return fmt.Errorf("size '%s' is too large for %s", s, v.value.Type())
+		}
+		v.value.SetUint(n)
+	default:
+		if n > math.MaxInt64 || v.value.OverflowInt(int64(n)) {
+			return fmt.Errorf("size '%s' is too large for %s", s, v.value.Type())
+		}
+		v.value.SetInt(int64(n))
+	}
+	return nil
+}
+
+func (v bytesValue) String() string {
+	if !v.value.IsValid() {
+		return "0B"
+	}
+	switch v.value.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return formatByteSize(v.value.Uint())
+	}
+	if n := v.value.Int(); n < 0 {
+		return strconv.FormatInt(n, 10) + "B"
+	}
+	return formatByteSize(uint64(v.value.Int()))
+}
+
+func (v bytesValue) Type() string { return "size" }
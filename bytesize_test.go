@@ -0,0 +1,114 @@
+package commandeer
+
+import (
+	"bytes"
+	"flag"
+	"strings"
+	"testing"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want uint64
+		err  string
+	}{
+		{in: "0", want: 0},
+		{in: "100", want: 100},
+		{in: "100B", want: 100},
+		{in: "512KiB", want: 512 << 10},
+		{in: "512kib", want: 512 << 10},
+		{in: "10M", want: 10 << 20},
+		{in: "10Mi", err: "invalid size"},
+		{in: "1.5GB", want: 1500000000},
+		{in: "2 KB", want: 2000},
+		{in: "0.5K", want: 512},
+		{in: "16EiB", err: "out of range"},
+		{in: "KB", err: "invalid size"},
+		{in: "-1KB", err: "invalid size"},
+		{in: "1..5MB", err: "invalid size"},
+	}
+	for _, tst := range tests {
+		got, err := parseByteSize(tst.in)
+		if tst.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tst.err) {
+				t.Errorf("parsing '%s': expected error containing '%s', got %d, %v", tst.in, tst.err, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tst.want {
+			t.Errorf("parsing '%s': expected %d, got %d, %v", tst.in, tst.want, got, err)
+		}
+	}
+}
+
+func TestFormatByteSize(t *testing.T) {
+	for n, want := range map[uint64]string{
+		0:          "0B",
+		1000:       "1KB",
+		1024:       "1KiB",
+		1536:       "1.5KiB",
+		1500000000: "1.5GB",
+		10 << 20:   "10MiB",
+		2000:       "2KB",
+		1234567:    "1234567B",
+		1230000:    "1.23MB",
+		1001:       "1001B",
+	} {
+		if got := formatByteSize(n); got != want {
+			t.Errorf("formatting %d: expected '%s', got '%s'", n, want, got)
+		}
+	}
+}
+
+type bytesMain struct {
+	Cache   ByteSize `help:"cache size"`
+	Buffer  int      `unit:"bytes" help:"buffer size"`
+	Limit   uint64   `unit:"bytes"`
+	Backlog int32    `unit:"bytes"`
+}
+
+func TestByteSizeFlags(t *testing.T) {
+	for name, newFlagger := range testFlaggers() {
+		t.Run(name, func(t *testing.T) {
+			mm := &bytesMain{}
+			mustSetenv(t, "BYTES_LIMIT", "1.5GB")
+			err := LoadArgsEnv(newFlagger(), mm, []string{"--cache", "512KiB", "--buffer=10M"}, "BYTES_", nil)
+			if err != nil {
+				t.Fatalf("loading: %v", err)
+			}
+			if mm.Cache != 512<<10 || mm.Buffer != 10<<20 || mm.Limit != 1500000000 {
+				t.Errorf("unexpected sizes: %+v", mm)
+			}
+		})
+	}
+
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.SetOutput(&bytes.Buffer{})
+	err := Flags(fs, &bytesMain{Cache: 64 << 20, Buffer: 4096})
+	if err != nil {
+		t.Fatalf("setting up flags: %v", err)
+	}
+	for name, def := range map[string]string{"cache": "64MiB", "buffer": "4KiB"} {
+		if got := fs.Lookup(name).DefValue; got != def {
+			t.Errorf("unexpected default for %s: '%s'", name, got)
+		}
+	}
+	usage := &bytes.Buffer{}
+	fs.SetOutput(usage)
+	fs.Usage()
+	if !strings.Contains(usage.String(), "-cache size") || !strings.Contains(usage.String(), "(default 64MiB)") {
+		t.Errorf("unexpected usage:\n%s", usage)
+	}
+	err = fs.Parse([]string{"-backlog=4GiB"})
+	if err == nil || !strings.Contains(err.Error(), "too large for int32") {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	err = Flags(flag.NewFlagSet("", flag.ContinueOnError), &struct {
+		Name string `unit:"bytes"`
+	}{})
+	if err == nil || !strings.Contains(err.Error(), "unit tag on non-integer field") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -1,7 +1,9 @@
 package cobrafy
 
 import (
+	"bytes"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
@@ -117,3 +119,29 @@ func TestExecute(t *testing.T) {
 		t.Fatalf("wrong error executing MyMain: %v", err)
 	}
 }
+
+type noOptMain struct {
+	Color string `noopt:"always" short:"c"`
+	Name  string
+}
+
+func TestCompletionNoOpt(t *testing.T) {
+	com, err := Command(&noOptMain{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	err = com.GenBashCompletion(buf)
+	if err != nil {
+		t.Fatalf("generating completion: %v", err)
+	}
+	// flags with a noopt value are completed without "=" or a second word
+	for _, line := range []string{`flags+=("--color")`, `flags+=("-c")`, `flags+=("--name=")`} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("expected '%s' in completion:\n%s", line, buf)
+		}
+	}
+	if strings.Contains(buf.String(), `two_word_flags+=("-c")`) {
+		t.Errorf("-c shouldn't take a second word:\n%s", buf)
+	}
+}
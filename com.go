@@ -9,15 +9,25 @@
 // where your struct doesn't have a Run() method, or you don't want to call it,
 // the Flags() function takes in a FlagSet and sets the flags based on the
 // passed in struct in the same way.
+//
+// For more control, New creates a Commandeer configured with options (the flag
+// set, args, environment, config sources, output, etc.) which has Flags, Load,
+// and Run methods. The package level functions are shorthand for it.
 package commandeer
 
 import (
 	"encoding"
 	"flag"
 	"fmt"
+	"io"
+	"math/big"
 	"net"
+	"net/netip"
+	"net/url"
 	"os"
 	"reflect"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 	"unicode"
@@ -41,9 +51,106 @@ import (
 // will be downcased and converted from camel case to be dash separated.
 //
 // 3. The "short" tag on a field will be used as the shorthand flag for that
-// field. It should be a single ascii character. This will only be used if the
-// Flagger is also a PFlagger.
+// field. It should be a single ascii character. If the Flagger isn't a
+// PFlagger (e.g. it's a flag.FlagSet), the shorthand is set up as another flag
+// which shares the same value, and the two are listed together in usage.
+//
+// 4. The "secret" tag on a field (or using the Secret type) masks the field's
+// value in usage output and error messages. Secret string fields are also
+// masked by their flag's String method.
+//
+// 5. The "group" tag on a field puts its flag in the named section of the usage
+// output. Each nested struct gets its own section as well, named after the
+// field unless it has a "group" tag, and described by its "help" tag. Sections
+// are printed in the order they are declared, as are the flags within them.
+//
+// 6. The "count" tag on an integer field (count:"true") makes its flag count
+// how many times it's given, so "-vvv" or "-v -v -v" sets it to 3 (or to 4
+// if its default is 1). It can still be set to a number with "--verbose=3" or
+// from the environment, in which case counting in the args starts from zero.
+// When the flags are parsed by the caller of this function on a flag set
+// without NoOptDefVal (like the stdlib's), "--verbose=true" counts once too,
+// since it can't be told apart from "--verbose".
+//
+// 7. The "noopt" tag gives the value a flag gets if it's given without one, so
+// with noopt:"always", "--color" means "--color=always". It uses pflag's
+// NoOptDefVal if the flag set has it. Otherwise (e.g. with the stdlib's flag
+// package) the flag is treated like a bool, so "-color=true" also means
+// "-color=always" and "-color never" doesn't give it a value, which its usage
+// notes.
+//
+// 8. Bool fields also get a "no-" flag (e.g. "no-cache" for "cache", or
+// "db.no-cache" for "db.cache") which sets them to false, unless the field has
+// the tag negatable:"false" or the name is used by another flag. It isn't
+// loaded from the environment, and usage lists the two together as
+// "--[no-]cache". Commandeer.Load and Run define these flags just before
+// loading, so flags defined on the flag set after Commandeer.Flags take their
+// names over, but this function defines them right away since the caller
+// parses the flags.
+//
+// 9. The "layout" tag on a time.Time field gives the layout (as for
+// time.Parse) its flag is parsed with, e.g. layout:"2006-01-02". It defaults to
+// RFC 3339.
+//
+// 10. The tag unit:"bytes" on an integer field lets its flag be set to a size
+// like "512KiB" or "1.5GB", and shows its default that way in usage, as for
+// the ByteSize type.
+//
+// 11. The tag duration:"extended" on a time.Duration field lets its flag also
+// use days and weeks, as in "7d" or "1w2d12h", and ISO 8601 durations like
+// "P7D" or "PT1H30M". Its default is shown in the largest of these units that
+// fits, e.g. "30d" rather than "720h0m0s".
+//
+// 12. The "path" tag on a string field (or using the Path type) expands a
+// leading "~" and environment variables in its flag's value, and resolves a
+// relative path from a config source like JSONFile against the directory it's
+// in. Options in the tag are checked after loading: "file" or "dir" for what
+// the path must be if it exists, "exists", "create" (which creates a missing
+// directory, or an empty file with the "file" option), "readable", and
+// "writable", e.g. path:"file,exists" or path:"dir,create".
+//
+// 13. Fields of type io.Reader, io.ReadCloser, io.Writer, io.WriteCloser, and
+// *os.File get a flag which is the path of a file to open for them, with "-"
+// meaning stdin or stdout. Files are opened as soon as everything is loaded
+// (rather than when first used) so that errors opening them are reported with
+// other configuration errors, and closed after Run returns (or by
+// Commandeer.Close). LoadArgsEnv and LoadEnv don't close them, so use
+// Commandeer.Load and Close for structs with file fields. Writers are truncated,
+// unless they have the tag mode:"append" or mode:"create" (which requires the
+// file to be new). An *os.File field is only opened for writing if it has a
+// "mode" tag.
+//
+// 14. An interface field with implementations registered by RegisterImpl
+// gets a flag which selects one by name, and flags for each implementation's
+// fields, prefixed with its flag name (e.g. "storage.dir"). Only the selected
+// implementation's flags are used. Usage lists each implementation's flags in
+// a section of its own.
+//
+// 15. A slice of structs gets a flag which adds an element each time it's
+// given, with its fields like "--upstream host=a,port=80". An element's fields
+// can also be set with indexed flags like "--upstream.0.host=a" (when args are
+// parsed by Load or Run, since they aren't defined on the flag set itself) and
+// environment variables like "APP_UPSTREAM_0_HOST", and the whole slice with a
+// JSON array of objects (in config files, or as the flag's value). Elements
+// with a "Validate() error" method are validated after loading, and their
+// paths and files are checked and opened like any others.
+//
+// Flags replaces the Usage func of the underlying flag set (if it has one) in
+// order to print them this way; see DefaultUsageTemplate, UsageTemplater, and
+// UsageDescriber to customize the output.
 func Flags(flags Flagger, main interface{}) error {
+	c := New(WithFlagSet(flags))
+	err := c.Flags(main)
+	if err != nil {
+		return err
+	}
+	c.fTr.negate()
+	return nil
+}
+
+// setup checks that main is a pointer to a struct, then sets up its flags and
+// the usage func.
+func (fTr *flagTracker) setup(main interface{}) error {
 	typ := reflect.TypeOf(main)
 	if typ.Kind() != reflect.Ptr {
 		return fmt.Errorf("value must be pointer to struct, but is %s", typ.Kind())
@@ -52,10 +159,15 @@ func Flags(flags Flagger, main interface{}) error {
 	mainVal := reflect.ValueOf(main).Elem()
 	mainTyp := mainVal.Type()
 	if mainTyp.Kind() != reflect.Struct {
-		return fmt.Errorf("value must be pointer to struct, but is pointer to %s", typ.Kind())
+		return fmt.Errorf("value must be pointer to struct, but is pointer to %s", mainTyp.Kind())
 	}
 
-	return setFlags(newFlagTracker(flags), main, "")
+	fTr.path = mainTyp.Name()
+	err := setFlags(fTr, main, "")
+	if err != nil {
+		return err
+	}
+	return fTr.installUsage(main)
 }
 
 type flagSet struct {
@@ -78,32 +190,128 @@ func Run(main interface{}) error {
 	return RunArgs(&flagSet{flag.CommandLine}, main, os.Args[1:])
 }
 
-var replacer *strings.Replacer = strings.NewReplacer("-", "_", ".", "_")
-
+// envNorm converts a flag name to an environment variable name by upper casing
+// it and replacing dashes, dots, and any other separators with underscores.
 func envNorm(name string) string {
-	return strings.ToUpper(replacer.Replace(name))
+	return strings.ToUpper(strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return '_'
+	}, name))
 }
 
 // loadEnv visits each flag in the FlagSet and sets its value based on
 // OS environment.
 func loadEnv(flagger Flagger, prefix string) (err error) {
-	if namer, ok := flagger.(FlagNamer); ok {
-		for _, name := range namer.Flags() {
-			envString := envNorm(prefix + name)
-			val, ok := os.LookupEnv(envString)
-			if ok {
-				err = flagger.Set(name, val)
-				if err != nil {
-					return fmt.Errorf("couldn't set %s to %s from env %s: %v", name, val, envString, err)
+	if _, ok := flagger.(FlagNamer); !ok {
+		return fmt.Errorf("unable to load flags from environment: flagger does not implement FlagNamer")
+	}
+	fTr := newFlagTracker(flagger)
+	fTr.env, fTr.envPrefix = true, prefix
+	return fTr.loadEnv()
+}
+
+// loadEnv sets each flag from the first of its environment variables which is
+// set. Flags which weren't set up by commandeer are loaded too if their names
+// can be listed.
+func (fTr *flagTracker) loadEnv() error {
+	fTr.resetRepeated()
+	names := fTr.names()
+	if names == nil {
+		for _, g := range fTr.groups {
+			for _, info := range g.flags {
+				names = append(names, info.name)
+			}
+		}
+	}
+	envNames := make(map[string][]string, len(names))
+	taken := make(map[string]struct{})
+	for _, name := range names {
+		if _, ok := fTr.aliases[name]; ok {
+			continue
+		}
+		if _, ok := fTr.negations[name]; ok {
+			continue
+		}
+		envNames[name] = fTr.envNames(nil, name)
+		if info, ok := fTr.infos[name]; ok {
+			envNames[name] = info.env
+		}
+		for _, envString := range envNames[name] {
+			taken[envString] = struct{}{}
+		}
+	}
+	for _, name := range names {
+		if _, ok := fTr.aliases[name]; ok {
+			continue
+		}
+		if _, ok := fTr.negations[name]; ok {
+			continue
+		}
+		secret := false
+		if info, ok := fTr.infos[name]; ok {
+			secret = info.secret
+		}
+		for _, envString := range envNames[name] {
+			val, source, err := fTr.lookupEnv(envString, secret, taken)
+			if err != nil {
+				return err
+			} else if source == "" {
+				continue
+			}
+			err = fTr.flagger.Set(name, val)
+			if err != nil {
+				if secret {
+					val = mask
 				}
+				return fmt.Errorf("couldn't set %s to %s from env %s: %v", name, val, source, err)
+			}
+			break
+		}
+	}
+	for _, t := range fTr.trackers(true) {
+		if t.elem {
+			continue
+		}
+		for _, v := range t.structSlices {
+			if err := v.indexedEnv(envNames[v.name], taken); err != nil {
+				return err
 			}
 		}
-	} else {
-		return fmt.Errorf("unable to load flags from environment: flagger does not implement FlagNamer")
 	}
 	return nil
 }
 
+// lookupEnv gets the value of an environment variable, and the name of the
+// variable it came from. If "<key>_FILE" is set instead, the value is read
+// from the file it names, following the convention for Docker and Kubernetes
+// secrets. This is skipped if "<key>_FILE" is in taken because it belongs to
+// another flag. The source is empty if the variable isn't set.
+func (fTr *flagTracker) lookupEnv(key string, secret bool, taken map[string]struct{}) (val, source string, err error) {
+	val, ok := fTr.getenv(key)
+	if ok {
+		source = key
+	}
+	fileKey := key + "_FILE"
+	if _, ok := taken[fileKey]; ok || (fTr.secretFilesOnly && !secret) {
+		return val, source, nil
+	}
+	path, fileOK := fTr.getenv(fileKey)
+	if !fileOK {
+		return val, source, nil
+	}
+	if ok {
+		return "", "", fmt.Errorf("both %s and %s are set", key, fileKey)
+	}
+	dat, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", fmt.Errorf("reading %s: %v", fileKey, err)
+	}
+	val = strings.TrimSuffix(string(dat), "\n")
+	return strings.TrimSuffix(val, "\r"), fileKey, nil
+}
+
 // LoadEnv calls LoadArgsEnv with args from the command line and the
 // default flag set.
 func LoadEnv(main interface{}, envPrefix string, parseElsewhere func(main interface{}) error) error {
@@ -114,7 +322,19 @@ func LoadEnv(main interface{}, envPrefix string, parseElsewhere func(main interf
 // tries setting each flag's value from the OS environment based on a
 // prefix concatenated to the flag name. The flag name is normalized
 // by removing any dashes or dots and replacing them with
-// underscores.
+// underscores. The name of each flag's environment variable is
+// appended to its usage text, e.g. "[$APP_VEHICLE_COLOR]".
+//
+// The "env" tag on a field overrides the variable names with a comma
+// separated list, e.g. `env:"DATABASE_URL,DB_URL"`. The first one which
+// is set is used. The prefix is prepended to each name unless it starts
+// with "!", e.g. `env:"!PORT"`. Use `env:"-"` to never load a field
+// from the environment.
+//
+// If "<NAME>_FILE" is set rather than one of a flag's variables, the flag
+// is set from the contents of the file it names (without a trailing
+// newline). It is an error for both to be set. Use New with
+// WithSecretFilesOnly to only do this for secret fields.
 //
 // One may also pass a "configElsewhere" function which can operate on
 // main arbitrarily. The purpose of this is to load config values from
@@ -128,58 +348,24 @@ func LoadEnv(main interface{}, envPrefix string, parseElsewhere func(main interf
 // can be configured (such as with a path to a config file). Once
 // configElsewhere runs, the environment and command line args are
 // re-set since they take higher precedence.
+//
+// Files opened for io.Reader, io.Writer, etc. fields (see Flags) aren't
+// closed, since there's nothing to close them with; use Commandeer.Load and
+// Commandeer.Close for structs with such fields.
 func LoadArgsEnv(flags Flagger, main interface{}, args []string, envPrefix string, configElsewhere func(main interface{}) error) error {
-	// setup flags
-	err := Flags(flags, main)
-	if err != nil {
-		return fmt.Errorf("calling Flags: %v", err)
-	}
-	// set values based on environment
-	err = loadEnv(flags, envPrefix)
-	if err != nil {
-		return fmt.Errorf("loading environment: %v", err)
-	}
-	// set values based on command line
-	err = flags.Parse(args)
-	if err != nil {
-		return fmt.Errorf("parsing command line args: %v", err)
-	}
-	// set values with configElsewhere
+	opts := []Option{WithFlagSet(flags), WithArgs(args), WithEnv(envPrefix)}
 	if configElsewhere != nil {
-		err = configElsewhere(main)
-		if err != nil {
-			return fmt.Errorf("executing external parsing func: %v", err)
-		}
-	}
-	// reset values with environment (precedence over configElsewhere)
-	err = loadEnv(flags, envPrefix)
-	if err != nil {
-		return fmt.Errorf("reloading environment: %v", err)
+		opts = append(opts, WithSources(SourceFunc(configElsewhere)))
 	}
-	// reset values with command line args (highest precedence)
-	err = flags.Parse(args)
-	if err != nil {
-		return fmt.Errorf("reparsing command line args: %v", err)
-	}
-	return nil
+	return New(opts...).Load(main)
 }
 
 // RunArgs is similar to Run, but the caller must specify their own flag set and
 // args to be parsed by that flag set.
 func RunArgs(flags Flagger, main interface{}, args []string) error {
-	err := Flags(flags, main)
-	if err != nil {
-		return fmt.Errorf("calling Flags: %v", err)
-	}
-	err = flags.Parse(args)
-	if err != nil {
-		return fmt.Errorf("parsing flags: %v", err)
-	}
-
-	if main, ok := main.(Runner); ok {
-		return main.Run()
-	}
-	return fmt.Errorf("called 'Run' with something which doesn't implement the 'Run() error' method.")
+	c := New(WithFlagSet(flags), WithArgs(args))
+	c.argsErr = "parsing flags"
+	return c.Run(main)
 }
 
 type stringSliceValue struct {
@@ -191,6 +377,13 @@ func (s stringSliceValue) Set(val string) error {
 	return nil
 }
 
+// Append adds to the slice rather than replacing it, which GNUFlagSet does
+// when a flag is repeated on the command line.
+func (s stringSliceValue) Append(val string) error {
+	*s.value = append(*s.value, strings.Split(val, ",")...)
+	return nil
+}
+
 func (s stringSliceValue) String() string {
 	if s.value != nil {
 		return "[" + strings.Join(*s.value, ",") + "]"
@@ -203,7 +396,6 @@ func (s stringSliceValue) Type() string {
 }
 
 func setFlags(flags *flagTracker, main interface{}, prefix string) error {
-	// TODO add tracking of flag names to ensure no duplicates
 	mainVal := reflect.ValueOf(main).Elem()
 	mainTyp := mainVal.Type()
 
@@ -213,173 +405,388 @@ func setFlags(flags *flagTracker, main interface{}, prefix string) error {
 		if ft.PkgPath != "" {
 			continue // this field is unexported
 		}
-		flagName := flagName(ft)
+		flagName := flagName(ft, flags.naming)
 		if flagName == "-" || flagName == "" {
 			continue // explicitly ignored
 		}
+		if flagName == "!embed" {
+			// the struct's fields get flags as if they were this one's
+			if ft.Type.Kind() != reflect.Struct {
+				return fmt.Errorf("!embed flag tag on non-struct field '%v'", ft.Name)
+			}
+			parent, parentPath := flags.group, flags.path
+			flags.group = flags.nestedGroup(ft, true)
+			flags.path += "." + ft.Name
+			err := setFlags(flags, f.Addr().Interface(), prefix)
+			flags.group, flags.path = parent, parentPath
+			if err != nil {
+				return err
+			}
+			continue
+		}
 		shorthand, err := flags.short(ft, flagName)
 		if err != nil {
 			return fmt.Errorf("getting shorthand for '%v': %v", ft.Name, err)
 		}
+		envKey := envNorm(flagName)
+		own := flagName
 		if prefix != "" {
-			flagName = prefix + "." + flagName
+			flagName = prefix + flags.separator + flagName
+			envKey = flags.envPath + "_" + envKey
 		}
+		flags.envKeys[flagName] = envKey
 
-		// first check supported concrete types
-		switch p := f.Addr().Interface().(type) {
-		case *time.Duration:
-			flags.duration(p, flagName, shorthand, time.Duration(f.Int()), flagHelp(ft))
-			continue
-		case *net.IPMask:
-			if !flags.pflag {
-				return fmt.Errorf("cannot support net.IPMask field at '%v' with stdlib flag pkg.", flagName)
-			}
-			flags.ipMask(p, flagName, shorthand, *p, flagHelp(ft))
-			continue
-		case *net.IPNet:
-			if !flags.pflag {
-				return fmt.Errorf("cannot support net.IPNet field at '%v' with stdlib flag pkg.", flagName)
-			}
-			flags.ipNet(p, flagName, shorthand, *p, flagHelp(ft))
-			continue
-		case *net.IP:
-			if !flags.pflag {
-				return fmt.Errorf("cannot support net.IP field at '%v' with stdlib flag pkg.", flagName)
+		path := flags.path + "." + ft.Name
+		err = flags.claim(flagName, path)
+		if err != nil {
+			return err
+		}
+		nested, err := setFlag(flags, ft, f, flagName, shorthand)
+		if err != nil {
+			return err
+		}
+		if !nested {
+			if noOpt, ok := ft.Tag.Lookup("noopt"); ok {
+				err = flags.noOpt(flagName, noOpt)
+				if err != nil {
+					return err
+				}
 			}
-			flags.ip(p, flagName, shorthand, *p, flagHelp(ft))
-			continue
-		case *[]net.IP:
-			if !flags.pflag {
-				return fmt.Errorf("cannot support []net.IP field at '%v' with stdlib flag pkg.", flagName)
+			if shorthand != "" && !flags.pflag {
+				flags.alias(flagName, shorthand)
 			}
-			flags.ipSlice(p, flagName, shorthand, *p, flagHelp(ft))
-			continue
-		case *[]string:
-			// special case support for string slice. multiple calls
-			// to set the string slice value will replace it rather
-			// than appending to it (as they would with
-			// e.g. pflag). This is necessary for cascading
-			// configuration from multiple sources (e.g. file, env,
-			// command line).
-			flags.vvarp(stringSliceValue{value: p}, flagName, shorthand, flagHelp(ft))
-			continue
-		case encodable:
-			flags.vvarp(encodedValue{p}, flagName, shorthand, flagHelp(ft))
+			flags.track(ft, f, flagName, flagName[:len(flagName)-len(own)], shorthand)
 			continue
 		}
+		delete(flags.fields, flagName) // structs don't get flags of their own
+
+		parent, parentPath, parentEnvPath := flags.group, flags.path, flags.envPath
+		flags.group = flags.nestedGroup(ft, false)
+		flags.path = path
+		flags.envPath = envKey
+		err = setFlags(flags, f.Addr().Interface(), flagName)
+		flags.group, flags.path, flags.envPath = parent, parentPath, parentEnvPath
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setFlag sets up the flag for a single field. It reports nested if the field
+// is a struct whose own fields should be turned into flags instead.
+func setFlag(flags *flagTracker, ft reflect.StructField, f reflect.Value, flagName, shorthand string) (nested bool, err error) {
+	help := flags.help(ft, flagName)
+	if isSecret(ft) {
+		return flags.secretFlag(ft, f, flagName, shorthand, help)
+	}
+	return setValueFlag(flags, ft, f, flagName, shorthand, help)
+}
+
+// secretFlag sets up the flag for a secret field. Strings use a secretValue,
+// while the flags of other fields are set up on a flag set of their own first
+// so that their Value can be wrapped with one which masks it.
+func (fTr *flagTracker) secretFlag(ft reflect.StructField, f reflect.Value, flagName, shorthand, help string) (nested bool, err error) {
+	if ft.Type.Kind() == reflect.String {
+		p := (*string)(f.Addr().UnsafePointer())
+		fTr.vvarp(secretValue{value: p}, flagName, shorthand, help)
+		return false, nil
+	}
+	gnu := NewGNUFlagSet(flagName, flag.ContinueOnError)
+	sub := fTr.subTracker(gnu)
+	nested, err = setValueFlag(sub, ft, f, flagName, "", help)
+	if nested || err != nil {
+		return nested, err
+	}
+	gf := gnu.Lookup(flagName)
+	if gf == nil {
+		return false, fmt.Errorf("couldn't set up secret flag '%v'", flagName)
+	}
+	fTr.value(maskedValue{Value: gf.Value, fTr: fTr}, flagName, shorthand, help)
+	if gf.NoOptDefVal != "" {
+		fTr.setNoOptDefVal(flagName, gf.NoOptDefVal)
+	}
+	fTr.adopt(sub)
+	return false, nil
+}
 
-		// now check basic kinds
+// setValueFlag sets up the flag for a field which isn't secret.
+func setValueFlag(flags *flagTracker, ft reflect.StructField, f reflect.Value, flagName, shorthand, help string) (nested bool, err error) {
+	if ft.Tag.Get("count") == "true" {
+		switch ft.Type.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		default:
+			return false, fmt.Errorf("count tag on non-integer field '%v'", flagName)
+		}
+		counter := &countValue{value: f, fresh: true, bareTrue: !flags.pflag}
+		counter.def = counter.count()
+		flags.vvarp(counter, flagName, shorthand, help)
+		flags.setNoOptDefVal(flagName, "+1")
+		flags.counters = append(flags.counters, counter)
+		return false, nil
+	}
+	if unit := ft.Tag.Get("unit"); unit != "" {
+		if unit != "bytes" {
+			return false, fmt.Errorf("unknown unit '%s' for '%v'", unit, flagName)
+		}
 		switch ft.Type.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		default:
+			return false, fmt.Errorf("unit tag on non-integer field '%v'", flagName)
+		}
+		flags.vvarp(bytesValue{value: f}, flagName, shorthand, help)
+		return false, nil
+	}
+
+	if tag, ok := ft.Tag.Lookup("path"); ok || ft.Type == pathType {
+		if ft.Type.Kind() != reflect.String {
+			return false, fmt.Errorf("path tag on non-string field '%v'", flagName)
+		}
+		opts, err := parsePathTag(tag)
+		if err != nil {
+			return false, fmt.Errorf("%v for '%v'", err, flagName)
+		}
+		path := &pathValue{name: flagName, value: f, opts: opts, fTr: flags}
+		flags.vvarp(path, flagName, shorthand, help)
+		flags.paths = append(flags.paths, path)
+		return false, nil
+	}
+
+	if ft.Type.Kind() == reflect.Interface {
+		if registered, ok := lookupImpls(ft.Type); ok {
+			return false, flags.implFlags(ft, f, flagName, shorthand, help, registered)
+		}
+	}
+	if isStructSlice(ft.Type) {
+		if _, ok := registeredValueFor(f); !ok {
+			v, err := newStructSliceValue(flags, f, flagName)
+			if err != nil {
+				return false, err
+			}
+			flags.vvarp(v, flagName, shorthand, help)
+			flags.structSlices = append(flags.structSlices, v)
+			return false, nil
+		}
+	}
+	if isFileField(ft.Type) {
+		file, err := newFileValue(ft, f, flagName)
+		if err != nil {
+			return false, err
+		}
+		flags.vvarp(file, flagName, shorthand, help)
+		flags.files = append(flags.files, file)
+		return false, nil
+	}
+
+	if value, ok := registeredValueFor(f); ok {
+		flags.value(value, flagName, shorthand, help)
+		return false, nil
+	}
+
+	// first check supported concrete types
+	switch p := f.Addr().Interface().(type) {
+	case *time.Duration:
+		switch format := ft.Tag.Get("duration"); format {
+		case "":
+		case "extended":
+			flags.vvarp(extendedDurationValue{p}, flagName, shorthand, help)
+			return false, nil
+		default:
+			return false, fmt.Errorf("unknown duration format '%s' for '%v'", format, flagName)
+		}
+		flags.duration(p, flagName, shorthand, time.Duration(f.Int()), help)
+		return false, nil
+	case *time.Time:
+		flags.vvarp(timeValue{p, timeLayout(ft)}, flagName, shorthand, help)
+		return false, nil
+	case **time.Time:
+		layout := timeLayout(ft)
+		flags.vvarp(ptrValue[time.Time]{p, func(t *time.Time) Value { return timeValue{t, layout} }}, flagName, shorthand, help)
+		return false, nil
+	case *url.URL:
+		flags.vvarp(urlValue{p}, flagName, shorthand, help)
+		return false, nil
+	case **url.URL:
+		flags.vvarp(ptrValue[url.URL]{p, func(u *url.URL) Value { return urlValue{u} }}, flagName, shorthand, help)
+		return false, nil
+	case **regexp.Regexp:
+		flags.vvarp(regexpValue{p}, flagName, shorthand, help)
+		return false, nil
+	case *os.FileMode:
+		flags.vvarp(fileModeValue{p}, flagName, shorthand, help)
+		return false, nil
+	case *netip.Addr:
+		flags.vvarp(addrValue{p}, flagName, shorthand, help)
+		return false, nil
+	case *netip.Prefix:
+		flags.vvarp(prefixValue{p}, flagName, shorthand, help)
+		return false, nil
+	case *netip.AddrPort:
+		flags.vvarp(addrPortValue{p}, flagName, shorthand, help)
+		return false, nil
+	case **net.TCPAddr:
+		flags.vvarp(tcpAddrValue{p}, flagName, shorthand, help)
+		return false, nil
+	case **net.UDPAddr:
+		flags.vvarp(udpAddrValue{p}, flagName, shorthand, help)
+		return false, nil
+	case **time.Location:
+		flags.vvarp(locationValue{p}, flagName, shorthand, help)
+		return false, nil
+	case *big.Int:
+		flags.vvarp(bigIntValue{p}, flagName, shorthand, help)
+		return false, nil
+	case **big.Int:
+		flags.vvarp(ptrValue[big.Int]{p, func(n *big.Int) Value { return bigIntValue{n} }}, flagName, shorthand, help)
+		return false, nil
+	case *big.Float:
+		flags.vvarp(bigFloatValue{p}, flagName, shorthand, help)
+		return false, nil
+	case **big.Float:
+		flags.vvarp(ptrValue[big.Float]{p, func(n *big.Float) Value { return bigFloatValue{n} }}, flagName, shorthand, help)
+		return false, nil
+	case *net.IPMask:
+		flags.ipMask(p, flagName, shorthand, *p, help)
+		return false, nil
+	case *net.IPNet:
+		flags.ipNet(p, flagName, shorthand, *p, help)
+		return false, nil
+	case *net.IP:
+		flags.ip(p, flagName, shorthand, *p, help)
+		return false, nil
+	case *[]net.IP:
+		flags.ipSlice(p, flagName, shorthand, *p, help)
+		return false, nil
+	case *[]string:
+		// special case support for string slice. multiple calls
+		// to set the string slice value will replace it rather
+		// than appending to it (as they would with
+		// e.g. pflag). This is necessary for cascading
+		// configuration from multiple sources (e.g. file, env,
+		// command line).
+		flags.vvarp(stringSliceValue{value: p}, flagName, shorthand, help)
+		return false, nil
+	case Value:
+		flags.value(p, flagName, shorthand, help)
+		return false, nil
+	case flag.Value:
+		flags.value(typedValue{p}, flagName, shorthand, help)
+		return false, nil
+	case encodable:
+		flags.vvarp(encodedValue{p}, flagName, shorthand, help)
+		return false, nil
+	}
+
+	// now check basic kinds
+	switch ft.Type.Kind() {
+	case reflect.String:
+		p := (*string)(f.Addr().UnsafePointer())
+		flags.string(p, flagName, shorthand, f.String(), help)
+	case reflect.Bool:
+		p := (*bool)(f.Addr().UnsafePointer())
+		flags.bool(p, flagName, shorthand, f.Bool(), help)
+	case reflect.Int:
+		p := (*int)(f.Addr().UnsafePointer())
+		val := int(f.Int())
+		flags.int(p, flagName, shorthand, val, help)
+	case reflect.Int64:
+		p := (*int64)(f.Addr().UnsafePointer())
+		flags.int64(p, flagName, shorthand, f.Int(), help)
+	case reflect.Float64:
+		p := (*float64)(f.Addr().UnsafePointer())
+		flags.float64(p, flagName, shorthand, f.Float(), help)
+	case reflect.Uint:
+		p := (*uint)(f.Addr().UnsafePointer())
+		val := uint(f.Uint())
+		flags.uint(p, flagName, shorthand, val, help)
+	case reflect.Uint64:
+		p := (*uint64)(f.Addr().UnsafePointer())
+		flags.uint64(p, flagName, shorthand, f.Uint(), help)
+	case reflect.Slice:
+		if !flags.pflag {
+			return false, fmt.Errorf("cannot support slice field at '%v' with stdlib flag pkg.", flagName)
+		}
+		switch ft.Type.Elem().Kind() {
 		case reflect.String:
-			p := (*string)(f.Addr().UnsafePointer())
-			flags.string(p, flagName, shorthand, f.String(), flagHelp(ft))
+			p := f.Addr().Interface().(*[]string)
+			flags.stringSlice(p, flagName, shorthand, *p, help)
 		case reflect.Bool:
-			p := (*bool)(f.Addr().UnsafePointer())
-			flags.bool(p, flagName, shorthand, f.Bool(), flagHelp(ft))
+			p := f.Addr().Interface().(*[]bool)
+			flags.boolSlice(p, flagName, shorthand, *p, help)
 		case reflect.Int:
-			p := (*int)(f.Addr().UnsafePointer())
-			val := int(f.Int())
-			flags.int(p, flagName, shorthand, val, flagHelp(ft))
-		case reflect.Int64:
-			p := (*int64)(f.Addr().UnsafePointer())
-			flags.int64(p, flagName, shorthand, f.Int(), flagHelp(ft))
-		case reflect.Float64:
-			p := (*float64)(f.Addr().UnsafePointer())
-			flags.float64(p, flagName, shorthand, f.Float(), flagHelp(ft))
+			p := f.Addr().Interface().(*[]int)
+			flags.intSlice(p, flagName, shorthand, *p, help)
 		case reflect.Uint:
-			p := (*uint)(f.Addr().UnsafePointer())
-			val := uint(f.Uint())
-			flags.uint(p, flagName, shorthand, val, flagHelp(ft))
-		case reflect.Uint64:
-			p := (*uint64)(f.Addr().UnsafePointer())
-			flags.uint64(p, flagName, shorthand, f.Uint(), flagHelp(ft))
-		case reflect.Slice:
-			if !flags.pflag {
-				return fmt.Errorf("cannot support slice field at '%v' with stdlib flag pkg.", flagName)
-			}
-			switch ft.Type.Elem().Kind() {
-			case reflect.String:
-				p := f.Addr().Interface().(*[]string)
-				flags.stringSlice(p, flagName, shorthand, *p, flagHelp(ft))
-			case reflect.Bool:
-				p := f.Addr().Interface().(*[]bool)
-				flags.boolSlice(p, flagName, shorthand, *p, flagHelp(ft))
-			case reflect.Int:
-				p := f.Addr().Interface().(*[]int)
-				flags.intSlice(p, flagName, shorthand, *p, flagHelp(ft))
-			case reflect.Uint:
-				p := f.Addr().Interface().(*[]uint)
-				flags.uintSlice(p, flagName, shorthand, *p, flagHelp(ft))
-			default:
-				return fmt.Errorf("encountered unsupported slice type/kind: %#v at %s", f, prefix)
-			}
-		case reflect.Float32:
-			if !flags.pflag {
-				return fmt.Errorf("cannot support float32 field at '%v' with stdlib flag pkg.", flagName)
-			}
-			p := (*float32)(f.Addr().UnsafePointer())
-			flags.float32(p, flagName, shorthand, *p, flagHelp(ft))
-		case reflect.Int16:
-			if !flags.pflag {
-				return fmt.Errorf("cannot support int16 field at '%v' with stdlib flag pkg.", flagName)
-			}
-			p := (*int16)(f.Addr().UnsafePointer())
-			flags.int16(p, flagName, shorthand, *p, flagHelp(ft))
-		case reflect.Int32:
-			if !flags.pflag {
-				return fmt.Errorf("cannot support int32 field at '%v' with stdlib flag pkg.", flagName)
-			}
-			p := (*int32)(f.Addr().UnsafePointer())
-			flags.int32(p, flagName, shorthand, *p, flagHelp(ft))
-		case reflect.Uint16:
-			if !flags.pflag {
-				return fmt.Errorf("cannot support uint16 field at '%v' with stdlib flag pkg.", flagName)
-			}
-			p := (*uint16)(f.Addr().UnsafePointer())
-			flags.uint16(p, flagName, shorthand, *p, flagHelp(ft))
-		case reflect.Uint32:
-			if !flags.pflag {
-				return fmt.Errorf("cannot support uint32 field at '%v' with stdlib flag pkg.", flagName)
-			}
-			p := (*uint32)(f.Addr().UnsafePointer())
-			flags.uint32(p, flagName, shorthand, *p, flagHelp(ft))
-		case reflect.Uint8:
-			if !flags.pflag {
-				return fmt.Errorf("cannot support uint8 field at '%v' with stdlib flag pkg.", flagName)
-			}
-			p := (*uint8)(f.Addr().UnsafePointer())
-			flags.uint8(p, flagName, shorthand, *p, flagHelp(ft))
-		case reflect.Int8:
-			if !flags.pflag {
-				return fmt.Errorf("cannot support int8 field at '%v' with stdlib flag pkg.", flagName)
-			}
-			p := (*int8)(f.Addr().UnsafePointer())
-			flags.int8(p, flagName, shorthand, *p, flagHelp(ft))
-		case reflect.Struct:
-			var newprefix string
-			// TODO test, what happens if there are flag name
-			// collisions (e.g. the struct at this level and the
-			// !embed struct have a field with the same name)?
-			if flagName == "!embed" {
-				newprefix = prefix
-			} else {
-				newprefix = flagName
-			}
-			err := setFlags(flags, f.Addr().Interface(), newprefix)
-			if err != nil {
-				return err
-			}
+			p := f.Addr().Interface().(*[]uint)
+			flags.uintSlice(p, flagName, shorthand, *p, help)
 		default:
-			return fmt.Errorf("encountered unsupported field type/kind: %#v at %s", f, prefix)
+			return false, fmt.Errorf("encountered unsupported slice type/kind: %#v at %s", f, flagName)
+		}
+	case reflect.Float32:
+		if !flags.pflag {
+			return false, fmt.Errorf("cannot support float32 field at '%v' with stdlib flag pkg.", flagName)
+		}
+		p := (*float32)(f.Addr().UnsafePointer())
+		flags.float32(p, flagName, shorthand, *p, help)
+	case reflect.Int16:
+		if !flags.pflag {
+			return false, fmt.Errorf("cannot support int16 field at '%v' with stdlib flag pkg.", flagName)
+		}
+		p := (*int16)(f.Addr().UnsafePointer())
+		flags.int16(p, flagName, shorthand, *p, help)
+	case reflect.Int32:
+		if !flags.pflag {
+			return false, fmt.Errorf("cannot support int32 field at '%v' with stdlib flag pkg.", flagName)
+		}
+		p := (*int32)(f.Addr().UnsafePointer())
+		flags.int32(p, flagName, shorthand, *p, help)
+	case reflect.Uint16:
+		if !flags.pflag {
+			return false, fmt.Errorf("cannot support uint16 field at '%v' with stdlib flag pkg.", flagName)
 		}
+		p := (*uint16)(f.Addr().UnsafePointer())
+		flags.uint16(p, flagName, shorthand, *p, help)
+	case reflect.Uint32:
+		if !flags.pflag {
+			return false, fmt.Errorf("cannot support uint32 field at '%v' with stdlib flag pkg.", flagName)
+		}
+		p := (*uint32)(f.Addr().UnsafePointer())
+		flags.uint32(p, flagName, shorthand, *p, help)
+	case reflect.Uint8:
+		if !flags.pflag {
+			return false, fmt.Errorf("cannot support uint8 field at '%v' with stdlib flag pkg.", flagName)
+		}
+		p := (*uint8)(f.Addr().UnsafePointer())
+		flags.uint8(p, flagName, shorthand, *p, help)
+	case reflect.Int8:
+		if !flags.pflag {
+			return false, fmt.Errorf("cannot support int8 field at '%v' with stdlib flag pkg.", flagName)
+		}
+		p := (*int8)(f.Addr().UnsafePointer())
+		flags.int8(p, flagName, shorthand, *p, help)
+	case reflect.Struct:
+		return true, nil
+	default:
+		return false, fmt.Errorf("encountered unsupported field type/kind: %#v at %s", f, flagName)
 	}
-	return nil
+	return false, nil
+}
+
+// timeLayout gets the layout for parsing a time field from its "layout" tag,
+// defaulting to RFC 3339.
+func timeLayout(field reflect.StructField) string {
+	if layout := field.Tag.Get("layout"); layout != "" {
+		return layout
+	}
+	return time.RFC3339Nano
 }
 
 // flagName finds a field's flag name. It first looks for a "flag" tag, then
 // tries to use the "json" tag, and final falls back to using the name of the
-// field after running it through "downcaseAndDash".
-func flagName(field reflect.StructField) (flagname string) {
+// field after running it through the naming strategy.
+func flagName(field reflect.StructField, naming NameStrategy) (flagname string) {
 	var ok bool
 	if flagname, ok = field.Tag.Lookup("flag"); ok {
 		return flagname
@@ -390,9 +797,34 @@ func flagName(field reflect.StructField) (flagname string) {
 	}
 	flagname = field.Name
 
-	return downcaseAndDash(flagname)
+	return naming(flagname)
 }
 
+// NameStrategy converts a field name to a flag name. It is used for fields
+// which don't have a "flag" or "json" tag. Environment variable names are
+// derived from the flag names (see LoadArgsEnv), but nested struct names are
+// always joined with an underscore.
+type NameStrategy func(fieldName string) string
+
+var (
+	// KebabCase converts "ServerPort" to "server-port". It is the default.
+	KebabCase NameStrategy = downcaseAndDash
+	// SnakeCase converts "ServerPort" to "server_port".
+	SnakeCase NameStrategy = func(fieldName string) string {
+		return strings.ReplaceAll(downcaseAndDash(fieldName), "-", "_")
+	}
+	// CamelCase converts "ServerPort" to "serverPort", and "HTTPServer" to
+	// "httpServer".
+	CamelCase NameStrategy = func(fieldName string) string {
+		words := strings.Split(downcaseAndDash(fieldName), "-")
+		for i := 1; i < len(words); i++ {
+			r, size := utf8.DecodeRuneInString(words[i])
+			words[i] = string(unicode.ToUpper(r)) + words[i][size:]
+		}
+		return strings.Join(words, "")
+	}
+)
+
 // downcaseAndDash converts a field name (expected to be camel case) to an all
 // lower case flag name with dashes between words that were previously cameled.
 // It attempts to handle upper case acronyms properly as well.
@@ -452,11 +884,28 @@ func (v encodedValue) String() string {
 }
 
 func (v encodedValue) Type() string {
-	t := reflect.TypeOf(v.encodable)
-	if name := t.Name(); name != "" {
-		return name
-	}
-	return t.String()
+	return typeName(v.encodable)
+}
+
+// typedValue adds a Type method to a flag.Value which doesn't have one.
+type typedValue struct {
+	flag.Value
+}
+
+func (v typedValue) Type() string {
+	return typeName(v.Value)
+}
+
+// IsBoolFlag passes through the wrapped Value's IsBoolFlag method if it has
+// one.
+func (v typedValue) IsBoolFlag() bool {
+	bf, ok := v.Value.(interface{ IsBoolFlag() bool })
+	return ok && bf.IsBoolFlag()
+}
+
+// typeName gets the name of v's type for use in usage output.
+func typeName(v interface{}) string {
+	return valueTypeName(reflect.TypeOf(v))
 }
 
 // flagTracker has methods for managing the set up of flags - it will utilize
@@ -465,18 +914,108 @@ type flagTracker struct {
 	flagger  Flagger
 	pflagger PFlagger
 	pflag    bool
-	shorts   map[rune]struct{}
+
+	// fields and shorts map each flag name and shorthand which has been
+	// used to the path of the field it was used for (e.g. Main.Sub.Field),
+	// and path is the path of the struct currently being walked.
+	fields map[string]string
+	shorts map[rune]string
+	path   string
+
+	// aliases maps shorthands which were set up as flags of their own (on
+	// flag sets without shorthand support) to the full flag name, and
+	// negations maps each "no-" flag to the bool flag it negates. negated
+	// is set once the "no-" flags have been set up.
+	aliases   map[string]string
+	negations map[string]string
+	negated   bool
+
+	// counters holds the Values of "count" tagged fields so they can be
+	// reset before parsing, and repeated holds other Values which add to
+	// their field when a flag is repeated.
+	counters []*countValue
+	repeated []interface{ reset() }
+
+	// paths holds the Values of path flags so they can be checked after
+	// loading, and pathBase is the directory of the source currently
+	// being loaded, which relative paths are resolved against.
+	paths    []*pathValue
+	pathBase string
+
+	// files holds the Values of file flags so they can be opened after
+	// loading and closed after running.
+	files []*fileValue
+
+	// impls holds the Values of interface fields with registered
+	// implementations, which are set once loading is done.
+	impls []*implValue
+
+	// structSlices holds the Values of slices of structs, which have
+	// indexed flags and environment variables for their elements.
+	// rewritten holds the slice's flag name, the rest of the indexed name,
+	// and the value of each indexed flag in the args last parsed, for
+	// errors.
+	structSlices []*structSliceValue
+	rewritten    [][3]string
+
+	// parent is set for subTrackers, and is the tracker they were created
+	// from. elem is set for those of elements of slices of structs (and
+	// their subTrackers), whose flag names aren't those of this flag set.
+	parent *flagTracker
+	elem   bool
+
+	// naming and separator determine flag names; see WithNaming and
+	// WithSeparator. envKeys maps each flag name to its environment variable
+	// name (without the prefix), and envPath is the part of that which
+	// comes from the struct currently being walked.
+	naming    NameStrategy
+	separator string
+	envKeys   map[string]string
+	envPath   string
+
+	// env is set if flags will also be loaded from environment
+	// variables starting with envPrefix.
+	env             bool
+	envPrefix       string
+	getenv          func(key string) (string, bool)
+	secretFilesOnly bool
+
+	// hidden holds secret values which couldn't be set, to be masked in
+	// errors which include them.
+	hidden []string
+
+	// infos holds every flag set up by commandeer by name.
+	infos map[string]*flagInfo
+
+	// groups holds the usage sections in the order they were first
+	// encountered; group is the one flags are currently being added to.
+	groups []*flagGroup
+	group  *flagGroup
+
+	// usageTemplate replaces DefaultUsageTemplate if it is set, and out
+	// replaces the flag set's output for usage.
+	usageTemplate string
+	out           io.Writer
 }
 
 // newFlagTracker sets up a flagTracker based on a flagger.
 func newFlagTracker(flagger Flagger) *flagTracker {
 	fTr := &flagTracker{
-		flagger: flagger,
-		shorts: map[rune]struct{}{
-			'h': {}, // "h" is always used for help, so we can't set it.
+		flagger:   flagger,
+		getenv:    os.LookupEnv,
+		infos:     make(map[string]*flagInfo),
+		fields:    make(map[string]string),
+		aliases:   make(map[string]string),
+		negations: make(map[string]string),
+		envKeys:   make(map[string]string),
+		naming:    KebabCase,
+		separator: ".",
+		shorts: map[rune]string{
+			'h': "help", // "h" is always used for help, so we can't set it.
 		},
 	}
 	fTr.pflagger, fTr.pflag = flagger.(PFlagger)
+	fTr.group = fTr.section("")
 	return fTr
 }
 
@@ -491,15 +1030,370 @@ func (fTr *flagTracker) short(field reflect.StructField, flagName string) (lette
 		if runeVal == utf8.RuneError || width > 1 {
 			return "", fmt.Errorf("'%s' is not a valid single ascii character.", short)
 		}
-		if _, ok := fTr.shorts[runeVal]; ok {
-			return "", fmt.Errorf("'%s' has already been used.", short)
+		path := fTr.path + "." + field.Name
+		if other, ok := fTr.shorts[runeVal]; ok {
+			return "", fmt.Errorf("'%s' has already been used by %s.", short, other)
+		}
+		if fTr.shorthandDefined(short) {
+			return "", fmt.Errorf("'%s' is already defined on the flag set.", short)
+		}
+		if !fTr.pflag {
+			// the shorthand will be another flag name
+			err := fTr.claim(short, path)
+			if err != nil {
+				return "", err
+			}
 		}
-		fTr.shorts[runeVal] = struct{}{}
+		fTr.shorts[runeVal] = path
 		return short, nil
 	}
 	return "", nil // no shorthand char available, but that's ok
 }
 
+// claim records that a flag name is used by the field at path, and returns an
+// error if it has already been used by another field or defined on the
+// underlying flag set by something other than commandeer.
+func (fTr *flagTracker) claim(name, path string) error {
+	if other, ok := fTr.fields[name]; ok {
+		return fmt.Errorf("flag '%s' is defined by both %s and %s", name, other, path)
+	}
+	if _, ok := fTr.lookup(name); ok {
+		return fmt.Errorf("flag '%s' for %s is already defined on the flag set", name, path)
+	}
+	fTr.fields[name] = path
+	return nil
+}
+
+// alias sets up shorthand as another name for the flag name, sharing the same
+// Value. This is how shorthands are supported on flag sets which don't have
+// them (like the stdlib's).
+func (fTr *flagTracker) alias(name, shorthand string) {
+	f, ok := fTr.lookup(name)
+	if !ok {
+		return
+	}
+	varMethod := reflect.ValueOf(fTr.flagger).MethodByName("Var")
+	if !varMethod.IsValid() {
+		panic("the given flag implementation does not have a Var method")
+	}
+	varMethod.Call([]reflect.Value{reflect.ValueOf(f.Value), reflect.ValueOf(shorthand), reflect.ValueOf(f.Usage)})
+	fTr.aliases[shorthand] = name
+}
+
+// resetRepeated makes the next increment of each counter flag start from
+// zero, and the next value given for each IP slice or element added to each
+// slice of structs replace the slice, so that repeated flags don't accumulate
+// when args are parsed more than once, or add to a value from the environment
+// or a config source.
+func (fTr *flagTracker) resetRepeated() {
+	for _, t := range fTr.trackers(true) {
+		for _, counter := range t.counters {
+			counter.fresh = true
+		}
+		for _, v := range t.repeated {
+			v.reset()
+		}
+		for _, v := range t.structSlices {
+			v.fresh = true
+		}
+	}
+}
+
+// trackers gets this tracker followed by the subTrackers the flags of its
+// interface fields' implementations and the elements of its slices of structs
+// were set up with, recursively. Unless all is set, only those of the
+// selected implementations are included, since the paths and files of the
+// others are never used.
+func (fTr *flagTracker) trackers(all bool) []*flagTracker {
+	ts := []*flagTracker{fTr}
+	for _, impl := range fTr.impls {
+		for _, name := range impl.names {
+			if all || name == impl.selected {
+				ts = append(ts, impl.subs[name].trackers(all)...)
+			}
+		}
+	}
+	for _, v := range fTr.structSlices {
+		v.load(v.value.Len())
+		for _, e := range v.elems {
+			ts = append(ts, e.sub.trackers(all)...)
+		}
+	}
+	return ts
+}
+
+// root gets the tracker at the top of a chain of subTrackers, which is the
+// one loading is done through.
+func (fTr *flagTracker) root() *flagTracker {
+	for fTr.parent != nil {
+		fTr = fTr.parent
+	}
+	return fTr
+}
+
+// subTracker creates a flagTracker for setting up some of this one's flags on
+// another flag set before they're added to this one. It uses the same naming
+// and environment, and starts at the struct currently being walked.
+func (fTr *flagTracker) subTracker(flagger Flagger) *flagTracker {
+	sub := newFlagTracker(flagger)
+	sub.parent, sub.elem = fTr, fTr.elem
+	sub.naming, sub.separator = fTr.naming, fTr.separator
+	sub.env, sub.envPrefix, sub.getenv = fTr.env, fTr.envPrefix, fTr.getenv
+	sub.secretFilesOnly = fTr.secretFilesOnly
+	sub.path, sub.envPath = fTr.path, fTr.envPath
+	return sub
+}
+
+// adopt takes over the Values a subTracker collected which need something
+// done before parsing or after loading, such as counters and paths.
+func (fTr *flagTracker) adopt(sub *flagTracker) {
+	for _, path := range sub.paths {
+		path.fTr = fTr
+	}
+	for _, counter := range sub.counters {
+		counter.bareTrue = !fTr.pflag
+	}
+	fTr.counters = append(fTr.counters, sub.counters...)
+	fTr.repeated = append(fTr.repeated, sub.repeated...)
+	fTr.paths = append(fTr.paths, sub.paths...)
+	fTr.files = append(fTr.files, sub.files...)
+	fTr.impls = append(fTr.impls, sub.impls...)
+	fTr.structSlices = append(fTr.structSlices, sub.structSlices...)
+}
+
+// negate sets up a "no-" flag for each negatable bool flag which sets it to
+// false, putting "no-" in front of the field's own part of the name. This is
+// done after all other flags are set up (and only once) so that a negation is
+// skipped if its name is used by another flag.
+func (fTr *flagTracker) negate() {
+	if fTr.negated {
+		return
+	}
+	fTr.negated = true
+	for _, g := range fTr.groups {
+		for _, info := range g.flags {
+			if !info.negatable {
+				continue
+			}
+			name := info.prefix + "no-" + info.name[len(info.prefix):]
+			_, taken := fTr.fields[name]
+			if _, defined := fTr.lookup(name); taken || defined {
+				info.negatable = false
+				continue
+			}
+			f, ok := fTr.lookup(info.name)
+			if !ok {
+				info.negatable = false
+				continue
+			}
+			fTr.vvarp(negatedValue{f.Value}, name, "", "sets -"+info.name+" to false")
+			fTr.setNoOptDefVal(name, "true")
+			fTr.fields[name] = fTr.fields[info.name]
+			fTr.negations[name] = info.name
+		}
+	}
+}
+
+// setNoOptDefVal reflectively sets the NoOptDefVal field of a flag (which
+// pflag.Flag and GNUFlag have) to the value the flag should get when it's given
+// without one. It does nothing for flag sets without the field.
+func (fTr *flagTracker) setNoOptDefVal(name, value string) {
+	fv, ok := fTr.lookupFlag(name)
+	if !ok {
+		return
+	}
+	if noOpt := fv.FieldByName("NoOptDefVal"); noOpt.Kind() == reflect.String && noOpt.CanSet() {
+		noOpt.SetString(value)
+	}
+}
+
+// noOpt lets the named flag be given without a value, in which case it's set
+// to value. It uses NoOptDefVal on flag sets which have it, and otherwise
+// replaces the flag's Value with a noOptValue.
+func (fTr *flagTracker) noOpt(name, value string) error {
+	if value == "" {
+		return fmt.Errorf("noopt tag for '%s' is empty", name)
+	}
+	fv, ok := fTr.lookupFlag(name)
+	if !ok {
+		return fmt.Errorf("couldn't look up '%s' to set its noopt value", name)
+	}
+	if noOpt := fv.FieldByName("NoOptDefVal"); noOpt.Kind() == reflect.String && noOpt.CanSet() {
+		noOpt.SetString(value)
+		return nil
+	}
+	fValue := fv.FieldByName("Value")
+	if !fValue.CanSet() || !fValue.CanInterface() {
+		return fmt.Errorf("flag set doesn't support noopt for '%s'", name)
+	}
+	inner, ok := fValue.Interface().(flag.Value)
+	wrapped := reflect.ValueOf(noOptValue{Value: inner, noOpt: value})
+	if !ok || !wrapped.Type().AssignableTo(fValue.Type()) {
+		return fmt.Errorf("flag set doesn't support noopt for '%s'", name)
+	}
+	fValue.Set(wrapped)
+	if usage := fv.FieldByName("Usage"); usage.Kind() == reflect.String && usage.CanSet() {
+		usage.SetString(noOptHelp(usage.String(), name))
+	}
+	return nil
+}
+
+// noOptHelp notes in the help text for a flag which is wrapped in a noOptValue
+// that a value must be given with "=", since the flag is treated like a bool.
+func noOptHelp(help, name string) string {
+	if help != "" {
+		help += " "
+	}
+	return help + "(a value must be given as -" + name + "=value)"
+}
+
+// bareArgs rewrites flags which are given without a value, and whose Values
+// have a non-empty bareValue, to be given that value instead of "true" (e.g.
+// "-v" to "-v=+1" for a count flag). This lets those Values tell a bare flag
+// from one explicitly set to "true" on flag sets without NoOptDefVal.
+func (fTr *flagTracker) bareArgs(args []string) []string {
+	if fTr.pflag {
+		return args
+	}
+	out := make([]string, 0, len(args))
+	for i, arg := range args {
+		if arg == "--" {
+			return append(out, args[i:]...)
+		}
+		name := strings.TrimLeft(arg, "-")
+		dashes := arg[:len(arg)-len(name)]
+		if dashes == "" || len(dashes) > 2 || strings.Contains(name, "=") {
+			out = append(out, arg)
+			continue
+		}
+		if f, ok := fTr.lookup(name); ok {
+			if bare, ok := f.Value.(interface{ bareValue() string }); ok && bare.bareValue() != "" {
+				arg += "=" + bare.bareValue()
+			}
+		}
+		out = append(out, arg)
+	}
+	return out
+}
+
+// noOptValue wraps a flag's Value on flag sets without NoOptDefVal (like the
+// stdlib's) so that the flag can be given without a value like a bool, in
+// which case it's set to noOpt. This means that explicitly setting it to
+// "true" does the same.
+type noOptValue struct {
+	flag.Value
+	noOpt string
+}
+
+func (v noOptValue) Set(val string) error {
+	if val == "true" {
+		val = v.noOpt
+	}
+	return v.Value.Set(val)
+}
+
+// IsBoolFlag means the flag doesn't need a value on the command line.
+func (v noOptValue) IsBoolFlag() bool { return true }
+
+// negatedValue is the Value for a "no-" flag. It sets the bool flag it wraps
+// to the opposite of what it's given.
+type negatedValue struct {
+	value flag.Value
+}
+
+func (n negatedValue) Set(val string) error {
+	b, err := strconv.ParseBool(val)
+	if err != nil {
+		return numError(err)
+	}
+	return n.value.Set(strconv.FormatBool(!b))
+}
+
+func (n negatedValue) String() string {
+	if n.value == nil {
+		return "false"
+	}
+	b, _ := strconv.ParseBool(n.value.String())
+	return strconv.FormatBool(!b)
+}
+
+func (n negatedValue) Type() string { return "bool" }
+
+// IsBoolFlag means the flag doesn't need a value on the command line.
+func (n negatedValue) IsBoolFlag() bool { return true }
+
+// shorthandDefined reflectively calls ShorthandLookup (if it exists) on the
+// underlying flag implementation to see whether a shorthand is already in use.
+func (fTr *flagTracker) shorthandDefined(short string) bool {
+	lookupMethod := reflect.ValueOf(fTr.flagger).MethodByName("ShorthandLookup")
+	if !lookupMethod.IsValid() {
+		return false
+	}
+	out := lookupMethod.Call([]reflect.Value{reflect.ValueOf(short)})
+	return len(out) == 1 && out[0].Kind() == reflect.Ptr && !out[0].IsNil()
+}
+
+// help gets the usage text for a field's flag. If flags are being loaded from
+// the environment, the variable name is appended (see listEnv).
+func (fTr *flagTracker) help(field reflect.StructField, flagName string) string {
+	help := flagHelp(field)
+	names := fTr.envNames(&field, flagName)
+	if len(names) == 0 || !fTr.listEnv(&field) {
+		return help
+	}
+	if help != "" {
+		help += " "
+	}
+	return help + "[$" + strings.Join(names, ", $") + "]"
+}
+
+// listEnv reports whether a flag's environment variables are listed in its
+// usage. They are if there is a prefix, since otherwise every flag would list
+// a variable, or if the field's "env" tag names them. field may be nil for
+// flags which weren't set up by commandeer.
+func (fTr *flagTracker) listEnv(field *reflect.StructField) bool {
+	if fTr.envPrefix != "" {
+		return true
+	}
+	if field == nil {
+		return false
+	}
+	_, ok := field.Tag.Lookup("env")
+	return ok
+}
+
+// envNames gets the names of the environment variables a flag is loaded from.
+// field may be nil for flags which weren't set up by commandeer.
+func (fTr *flagTracker) envNames(field *reflect.StructField, flagName string) []string {
+	if !fTr.env {
+		return nil
+	}
+	key, ok := fTr.envKeys[flagName]
+	if !ok {
+		key = envNorm(flagName)
+	}
+	if field == nil {
+		return []string{envNorm(fTr.envPrefix) + key}
+	}
+	tag, ok := field.Tag.Lookup("env")
+	if !ok {
+		return []string{envNorm(fTr.envPrefix) + key}
+	}
+	if tag == "-" {
+		return nil
+	}
+	var names []string
+	for _, name := range strings.Split(tag, ",") {
+		name = strings.TrimSpace(name)
+		if strings.HasPrefix(name, "!") {
+			names = append(names, name[1:])
+		} else if name != "" {
+			names = append(names, envNorm(fTr.envPrefix)+name)
+		}
+	}
+	return names
+}
+
 func (fTr *flagTracker) string(p *string, name, shorthand, value, usage string) {
 	if fTr.pflag {
 		fTr.pflagger.StringVarP(p, name, shorthand, value, usage)
@@ -570,19 +1464,25 @@ func (fTr *flagTracker) intSlice(p *[]int, name, shorthand string, value []int,
 	fTr.pflagger.IntSliceVarP(p, name, shorthand, value, usage)
 }
 func (fTr *flagTracker) ipSlice(p *[]net.IP, name, shorthand string, value []net.IP, usage string) {
-	fTr.pflagger.IPSliceVarP(p, name, shorthand, value, usage)
+	*p = value
+	v := &repeatedSlice[net.IP]{sliceValue: sliceValue[net.IP]{p, parseIP, net.IP.String, "ipSlice"}, fresh: true}
+	fTr.vvarp(v, name, shorthand, usage)
+	fTr.repeated = append(fTr.repeated, v)
 }
 func (fTr *flagTracker) float32(p *float32, name, shorthand string, value float32, usage string) {
 	fTr.pflagger.Float32VarP(p, name, shorthand, value, usage)
 }
 func (fTr *flagTracker) ipMask(p *net.IPMask, name, shorthand string, value net.IPMask, usage string) {
-	fTr.pflagger.IPMaskVarP(p, name, shorthand, value, usage)
+	*p = value
+	fTr.vvarp(ipMaskValue{p}, name, shorthand, usage)
 }
 func (fTr *flagTracker) ipNet(p *net.IPNet, name, shorthand string, value net.IPNet, usage string) {
-	fTr.pflagger.IPNetVarP(p, name, shorthand, value, usage)
+	*p = value
+	fTr.vvarp(ipNetValue{p}, name, shorthand, usage)
 }
 func (fTr *flagTracker) ip(p *net.IP, name, shorthand string, value net.IP, usage string) {
-	fTr.pflagger.IPVarP(p, name, shorthand, value, usage)
+	*p = value
+	fTr.vvarp(ipValue{p}, name, shorthand, usage)
 }
 func (fTr *flagTracker) uint8(p *uint8, name, shorthand string, value uint8, usage string) {
 	fTr.pflagger.Uint8VarP(p, name, shorthand, value, usage)
@@ -603,6 +1503,16 @@ func (fTr *flagTracker) int32(p *int32, name, shorthand string, value int32, usa
 	fTr.pflagger.Int32VarP(p, name, shorthand, value, usage)
 }
 
+// value sets up a flag for a field which is a Value itself. Values which
+// act like bools (with an IsBoolFlag method returning true) can be given
+// without a value on flag sets which use NoOptDefVal as well.
+func (fTr *flagTracker) value(value Value, name, shorthand, usage string) {
+	fTr.vvarp(value, name, shorthand, usage)
+	if bf, ok := value.(interface{ IsBoolFlag() bool }); ok && bf.IsBoolFlag() {
+		fTr.setNoOptDefVal(name, "true")
+	}
+}
+
 // vvarp reflectively calls VarP or Var on the underlying pflagger or
 // flagger. We can't add VarP to the pflagger interface because it
 // takes a pflag.Value and referring to that would necessitate
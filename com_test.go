@@ -1,12 +1,15 @@
 package commandeer
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"reflect"
 	"strings"
 	"testing"
+	"text/template"
 	"time"
 
 	"github.com/jaffee/commandeer/test"
@@ -142,6 +145,16 @@ func TestLoadArgsEnv(t *testing.T) {
 	}
 }
 
+// testFlaggers returns a constructor for each kind of flag set commandeer
+// supports, by name, for tests which should pass with all of them.
+func testFlaggers() map[string]func() Flagger {
+	return map[string]func() Flagger{
+		"stdlib": func() Flagger { return flag.NewFlagSet("", flag.ContinueOnError) },
+		"pflag":  func() Flagger { return pflag.NewFlagSet("", pflag.ContinueOnError) },
+		"gnu":    func() Flagger { return NewGNUFlagSet("", flag.ContinueOnError) },
+	}
+}
+
 func mustSetenv(t *testing.T, key, val string) {
 	err := os.Setenv(key, val)
 	if err != nil {
@@ -577,3 +590,597 @@ func TestRunSimpleMain(t *testing.T) {
 		}
 	}
 }
+
+type envTagMain struct {
+	DB      string `env:"DATABASE_URL,DB_URL"`
+	Port    int    `env:"!PORT"`
+	Secret  string `env:"-"`
+	Verbose bool
+}
+
+func TestLoadArgsEnvTags(t *testing.T) {
+	mustSetenv(t, "TAG_DB_URL", "second")
+	mustSetenv(t, "TAG_DB", "ignored")
+	mustSetenv(t, "PORT", "8080")
+	mustSetenv(t, "TAG_PORT", "1")
+	mustSetenv(t, "TAG_SECRET", "nope")
+	mustSetenv(t, "TAG_VERBOSE", "true")
+	defer func() {
+		for _, key := range []string{"TAG_DB_URL", "TAG_DB", "PORT", "TAG_PORT", "TAG_SECRET", "TAG_VERBOSE"} {
+			os.Unsetenv(key)
+		}
+	}()
+
+	mm := &envTagMain{}
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	err := LoadArgsEnv(fs, mm, []string{}, "TAG_", nil)
+	if err != nil {
+		t.Fatalf("LoadArgsEnv: %v", err)
+	}
+	if mm.DB != "second" || mm.Port != 8080 || mm.Secret != "" || !mm.Verbose {
+		t.Errorf("unexpected values after loading env: %+v", mm)
+	}
+
+	mustSetenv(t, "TAG_DATABASE_URL", "first")
+	defer os.Unsetenv("TAG_DATABASE_URL")
+	mm = &envTagMain{}
+	fs = flag.NewFlagSet("", flag.ContinueOnError)
+	err = LoadArgsEnv(fs, mm, []string{}, "TAG_", nil)
+	if err != nil {
+		t.Fatalf("LoadArgsEnv: %v", err)
+	}
+	if mm.DB != "first" {
+		t.Errorf("expected first env var to win, got: %s", mm.DB)
+	}
+	if f := fs.Lookup("db"); f.Usage != "[$TAG_DATABASE_URL, $TAG_DB_URL]" {
+		t.Errorf("unexpected usage for db: %s", f.Usage)
+	}
+	if f := fs.Lookup("secret"); f.Usage != "" {
+		t.Errorf("unexpected usage for secret: %s", f.Usage)
+	}
+
+	// the prefix is normalized the same way for tagged and derived names
+	mm = &envTagMain{}
+	err = New(
+		WithFlagSet(flag.NewFlagSet("", flag.ContinueOnError)),
+		WithArgs(nil),
+		WithEnv("my-app."),
+		WithEnvLookup(envMap(map[string]string{"MY_APP_DB_URL": "tagged", "MY_APP_VERBOSE": "true"})),
+	).Load(mm)
+	if err != nil {
+		t.Fatalf("loading: %v", err)
+	}
+	if mm.DB != "tagged" || !mm.Verbose {
+		t.Errorf("unexpected values with a non-normalized prefix: %+v", mm)
+	}
+}
+
+type fileEnvMain struct {
+	Password Secret
+	Name     string
+	Cert     string
+	CertFile string
+}
+
+func TestLoadArgsEnvFile(t *testing.T) {
+	dir := t.TempDir()
+	pwFile := dir + "/pw"
+	if err := os.WriteFile(pwFile, []byte("hunter2\n"), 0600); err != nil {
+		t.Fatalf("writing password file: %v", err)
+	}
+	nameFile := dir + "/name"
+	if err := os.WriteFile(nameFile, []byte("bob"), 0600); err != nil {
+		t.Fatalf("writing name file: %v", err)
+	}
+	mustSetenv(t, "FE_PASSWORD_FILE", pwFile)
+	mustSetenv(t, "FE_NAME_FILE", nameFile)
+	mustSetenv(t, "FE_CERT_FILE", "/not/read")
+	defer func() {
+		for _, key := range []string{"FE_PASSWORD_FILE", "FE_NAME_FILE", "FE_CERT_FILE"} {
+			os.Unsetenv(key)
+		}
+	}()
+
+	mm := &fileEnvMain{}
+	err := LoadArgsEnv(flag.NewFlagSet("", flag.ContinueOnError), mm, []string{}, "FE_", nil)
+	if err != nil {
+		t.Fatalf("LoadArgsEnv: %v", err)
+	}
+	if mm.Password != "hunter2" || mm.Name != "bob" {
+		t.Errorf("unexpected values from files: %s %s", string(mm.Password), mm.Name)
+	}
+	if mm.Cert != "" || mm.CertFile != "/not/read" {
+		t.Errorf("CERT_FILE should belong to cert-file: '%s' '%s'", mm.Cert, mm.CertFile)
+	}
+
+	mm = &fileEnvMain{}
+	err = New(WithFlagSet(flag.NewFlagSet("", flag.ContinueOnError)), WithArgs(nil), WithEnv("FE_"), WithSecretFilesOnly(true)).Load(mm)
+	if err != nil {
+		t.Fatalf("LoadArgsEnv: %v", err)
+	}
+	if mm.Password != "hunter2" || mm.Name != "" {
+		t.Errorf("unexpected values with WithSecretFilesOnly: %s %s", string(mm.Password), mm.Name)
+	}
+
+	mustSetenv(t, "FE_PASSWORD", "both")
+	defer os.Unsetenv("FE_PASSWORD")
+	err = LoadArgsEnv(flag.NewFlagSet("", flag.ContinueOnError), &fileEnvMain{}, []string{}, "FE_", nil)
+	if err == nil || !strings.Contains(err.Error(), "both FE_PASSWORD and FE_PASSWORD_FILE are set") {
+		t.Fatalf("expected error for both forms, got: %v", err)
+	}
+}
+
+type Server struct {
+	Port int
+	Host string `short:"s"`
+}
+
+type dupMain struct {
+	Port   int
+	Server Server `flag:"!embed"`
+}
+
+type dbEmbed struct {
+	Port   int
+	Server Server `flag:"!embed"`
+}
+
+type nestedDupMain struct {
+	DB dbEmbed
+}
+
+type dupShortMain struct {
+	Size   int `short:"s"`
+	Server Server
+}
+
+func TestDuplicateFlags(t *testing.T) {
+	tests := []struct {
+		main interface{}
+		pre  string
+		err  string
+	}{
+		{
+			main: &dupMain{},
+			err:  "flag 'port' is defined by both dupMain.Port and dupMain.Server.Port",
+		},
+		{
+			main: &nestedDupMain{},
+			err:  "flag 'db.port' is defined by both nestedDupMain.DB.Port and nestedDupMain.DB.Server.Port",
+		},
+		{
+			main: &struct {
+				Port int `flag:"!embed"`
+			}{},
+			err: "!embed flag tag on non-struct field 'Port'",
+		},
+		{
+			main: &dupShortMain{},
+			err:  "getting shorthand for 'Host': 's' has already been used by dupShortMain.Size.",
+		},
+		{
+			main: &Server{},
+			pre:  "host",
+			err:  "flag 'host' for Server.Host is already defined on the flag set",
+		},
+		{
+			main: &Server{},
+			pre:  "s",
+			err:  "getting shorthand for 'Host': 's' is already defined on the flag set.",
+		},
+	}
+	for i, tst := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			fs := pflag.NewFlagSet("dups", pflag.ContinueOnError)
+			if len(tst.pre) == 1 {
+				fs.StringP("other", tst.pre, "", "")
+			} else if tst.pre != "" {
+				fs.String(tst.pre, "", "")
+			}
+			err := Flags(fs, tst.main)
+			if err == nil || err.Error() != tst.err {
+				t.Fatalf("expected '%s', got '%v'", tst.err, err)
+			}
+		})
+	}
+
+	// the stdlib flag package panics on redefinition, so make sure
+	// that doesn't happen either.
+	err := Flags(flag.NewFlagSet("dups", flag.ContinueOnError), &dupMain{})
+	if err == nil || !strings.Contains(err.Error(), "dupMain.Server.Port") {
+		t.Fatalf("unexpected error with stdlib flags: %v", err)
+	}
+}
+
+type nestedEmbedMain struct {
+	DB struct {
+		Name   string
+		Server Server `flag:"!embed"`
+	}
+}
+
+func TestNestedEmbed(t *testing.T) {
+	for name, newFlagger := range testFlaggers() {
+		t.Run(name, func(t *testing.T) {
+			mm := &nestedEmbedMain{}
+			mustSetenv(t, "NE_DB_PORT", "5432")
+			defer os.Unsetenv("NE_DB_PORT")
+			err := LoadArgsEnv(newFlagger(), mm, []string{"--db.name", "n", "--db.host", "h"}, "NE_", nil)
+			if err != nil {
+				t.Fatalf("loading: %v", err)
+			}
+			if mm.DB.Name != "n" || mm.DB.Server.Host != "h" || mm.DB.Server.Port != 5432 {
+				t.Errorf("unexpected values: %+v", mm)
+			}
+		})
+	}
+}
+
+func TestNameStrategies(t *testing.T) {
+	tests := []struct {
+		input string
+		snake string
+		camel string
+	}{
+		{input: "A", snake: "a", camel: "a"},
+		{input: "ServerPort", snake: "server_port", camel: "serverPort"},
+		{input: "HTTPServer", snake: "http_server", camel: "httpServer"},
+		{input: "MyURL", snake: "my_url", camel: "myUrl"},
+	}
+	for i, tst := range tests {
+		if out := SnakeCase(tst.input); out != tst.snake {
+			t.Errorf("test: %d, '%v' is not '%v'", i, out, tst.snake)
+		}
+		if out := CamelCase(tst.input); out != tst.camel {
+			t.Errorf("test: %d, '%v' is not '%v'", i, out, tst.camel)
+		}
+	}
+}
+
+type namingMain struct {
+	ServerPort int
+	Database   struct {
+		MaxConns int
+		URL      string `flag:"the-url"`
+	}
+}
+
+func TestNaming(t *testing.T) {
+	tests := []struct {
+		naming    NameStrategy
+		separator string
+		names     []string
+		env       map[string]string
+	}{
+		{naming: KebabCase, separator: ".", names: []string{"server-port", "database.max-conns", "database.the-url"},
+			env: map[string]string{"NM_DATABASE_MAX_CONNS": "9", "NM_DATABASE_THE_URL": "db://"}},
+		{naming: SnakeCase, separator: "_", names: []string{"server_port", "database_max_conns", "database_the-url"},
+			env: map[string]string{"NM_DATABASE_MAX_CONNS": "9", "NM_DATABASE_THE_URL": "db://"}},
+		{naming: CamelCase, separator: "-", names: []string{"serverPort", "database-maxConns", "database-the-url"},
+			env: map[string]string{"NM_DATABASE_MAXCONNS": "9", "NM_DATABASE_THE_URL": "db://"}},
+		{naming: strings.ToUpper, separator: "/", names: []string{"SERVERPORT", "DATABASE/MAXCONNS", "DATABASE/the-url"},
+			env: map[string]string{"NM_DATABASE_MAXCONNS": "9", "NM_DATABASE_THE_URL": "db://"}},
+	}
+	for i, tst := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			for k, v := range tst.env {
+				mustSetenv(t, k, v)
+				defer os.Unsetenv(k)
+			}
+			fs := flag.NewFlagSet("", flag.ContinueOnError)
+			mm := &namingMain{}
+			err := New(WithFlagSet(fs), WithArgs([]string{"-" + tst.names[0], "80"}), WithEnv("NM_"), WithNaming(tst.naming), WithSeparator(tst.separator)).Load(mm)
+			if err != nil {
+				t.Fatalf("loading: %v", err)
+			}
+			for _, name := range tst.names {
+				if fs.Lookup(name) == nil {
+					t.Errorf("couldn't look up '%s'", name)
+				}
+			}
+			if mm.ServerPort != 80 || mm.Database.MaxConns != 9 || mm.Database.URL != "db://" {
+				t.Errorf("unexpected values: %+v", mm)
+			}
+		})
+	}
+}
+
+type shortMain struct {
+	Verbose bool   `short:"v"`
+	Name    string `short:"n"`
+	V       string
+}
+
+func TestStdlibShorthand(t *testing.T) {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	err := Flags(fs, &shortMain{})
+	if err == nil || err.Error() != "flag 'v' is defined by both shortMain.Verbose and shortMain.V" {
+		t.Fatalf("expected conflict between shorthand and flag name, got: %v", err)
+	}
+
+	type main struct {
+		Verbose bool   `short:"v"`
+		Name    string `short:"n"`
+	}
+	mm := &main{}
+	fs = flag.NewFlagSet("", flag.ContinueOnError)
+	err = New(WithFlagSet(fs), WithArgs([]string{"-v", "-n", "bob"}), WithEnv("SH_"), WithEnvLookup(func(key string) (string, bool) {
+		if key == "SH_N" || key == "SH_V" {
+			t.Errorf("shorthand %s shouldn't be loaded from the environment", key)
+		}
+		return "", false
+	})).Load(mm)
+	if err != nil {
+		t.Fatalf("loading: %v", err)
+	}
+	if !mm.Verbose || mm.Name != "bob" {
+		t.Errorf("shorthands not set: %+v", mm)
+	}
+	if fs.Lookup("v").Value != fs.Lookup("verbose").Value {
+		t.Errorf("shorthand should share its Value")
+	}
+}
+
+type negateMain struct {
+	Cache   bool
+	Color   bool
+	Debug   bool `negatable:"false"`
+	Fast    bool
+	NoFast  string
+	Verbose bool
+}
+
+func TestNegatableBools(t *testing.T) {
+	for name, newFlagger := range testFlaggers() {
+		t.Run(name, func(t *testing.T) {
+			mm := &negateMain{Cache: true, Color: true, Verbose: true}
+			fs := newFlagger()
+			err := New(WithFlagSet(fs), WithArgs([]string{"--no-cache", "--no-fast", "x", "--verbose"}), WithEnv("NEG_"), WithEnvLookup(func(key string) (string, bool) {
+				if strings.HasPrefix(key, "NEG_NO_") && !strings.HasPrefix(key, "NEG_NO_FAST") {
+					t.Errorf("negation %s shouldn't be loaded from the environment", key)
+				}
+				return envMap(map[string]string{"NEG_COLOR": "false", "NEG_VERBOSE": "false"})(key)
+			})).Load(mm)
+			if err != nil {
+				t.Fatalf("loading: %v", err)
+			}
+			if mm.Cache || mm.Color || !mm.Verbose || mm.NoFast != "x" {
+				t.Errorf("unexpected values: %+v", mm)
+			}
+			err = fs.Set("no-verbose", "true")
+			if err != nil || mm.Verbose {
+				t.Errorf("setting no-verbose: %v %+v", err, mm)
+			}
+			if err := fs.Set("no-debug", "true"); err == nil {
+				t.Errorf("debug shouldn't be negatable")
+			}
+		})
+	}
+}
+
+type nestedNegateMain struct {
+	DB struct {
+		Cache bool
+	}
+	Verbose bool
+}
+
+func TestNegatableNested(t *testing.T) {
+	mm := &nestedNegateMain{}
+	mm.DB.Cache = true
+	fs := flag.NewFlagSet("neg", flag.ContinueOnError)
+	buf := &bytes.Buffer{}
+	fs.SetOutput(buf)
+	c := New(WithFlagSet(fs), WithArgs([]string{"-db.no-cache", "-no-verbose"}))
+	err := c.Flags(mm)
+	if err != nil {
+		t.Fatalf("calling Flags: %v", err)
+	}
+	// defined after Flags, so the negation is skipped rather than panicking
+	mine := fs.Bool("no-verbose", false, "mine")
+	err = c.Load(mm)
+	if err != nil {
+		t.Fatalf("loading: %v", err)
+	}
+	if mm.DB.Cache || !*mine {
+		t.Errorf("unexpected values: %+v %v", mm, *mine)
+	}
+	fs.Usage()
+	if !strings.Contains(buf.String(), "  -db.[no-]cache\n") || !strings.Contains(buf.String(), "  -verbose\n") {
+		t.Errorf("unexpected usage:\n%s", buf.String())
+	}
+}
+
+type countMain struct {
+	Verbose int   `short:"v" count:"true"`
+	Level   uint8 `count:"true"`
+}
+
+func TestCountFlags(t *testing.T) {
+	tests := []struct {
+		flags Flagger
+		args  []string
+		env   map[string]string
+		init  countMain
+		exp   countMain
+		err   string
+	}{
+		{flags: NewGNUFlagSet("", flag.ContinueOnError), args: []string{"-vvv", "--level"}, exp: countMain{Verbose: 3, Level: 1}},
+		{flags: pflag.NewFlagSet("", pflag.ContinueOnError), args: []string{"-vv", "-v", "--level=7"}, exp: countMain{Verbose: 3, Level: 7}},
+		{flags: flag.NewFlagSet("", flag.ContinueOnError), args: []string{"-v", "-verbose", "-v"}, exp: countMain{Verbose: 3}},
+		{flags: NewGNUFlagSet("", flag.ContinueOnError), args: []string{"--verbose=5", "-v"}, exp: countMain{Verbose: 6}},
+		{flags: NewGNUFlagSet("", flag.ContinueOnError), env: map[string]string{"CNT_VERBOSE": "2"}, exp: countMain{Verbose: 2}},
+		{flags: pflag.NewFlagSet("", pflag.ContinueOnError), args: []string{"-v"}, env: map[string]string{"CNT_VERBOSE": "2"}, exp: countMain{Verbose: 1}},
+		{flags: flag.NewFlagSet("", flag.ContinueOnError), args: []string{"-v", "-level"}, init: countMain{Verbose: 2, Level: 1}, exp: countMain{Verbose: 3, Level: 2}},
+		{flags: NewGNUFlagSet("", flag.ContinueOnError), args: []string{"-vv"}, init: countMain{Verbose: 2}, exp: countMain{Verbose: 4}},
+		{flags: NewGNUFlagSet("", flag.ContinueOnError), args: []string{"-v"}, env: map[string]string{"CNT_VERBOSE": "5"}, init: countMain{Verbose: 2}, exp: countMain{Verbose: 1}},
+		{flags: flag.NewFlagSet("", flag.ContinueOnError), args: []string{"-verbose=true"}, err: `invalid boolean value "true" for -verbose: invalid syntax`},
+		{flags: NewGNUFlagSet("", flag.ContinueOnError), args: []string{"--verbose=true"}, err: `invalid argument "true" for "--verbose" flag: invalid syntax`},
+		{flags: pflag.NewFlagSet("", pflag.ContinueOnError), env: map[string]string{"CNT_VERBOSE": "true"}, err: `couldn't set verbose to true from env CNT_VERBOSE: invalid argument "true" for "-v, --verbose" flag: invalid syntax`},
+	}
+	for i, tst := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			if setter, ok := tst.flags.(interface{ SetOutput(io.Writer) }); ok {
+				setter.SetOutput(io.Discard)
+			}
+			mm := &tst.init
+			// a source means args are parsed twice
+			err := New(WithFlagSet(tst.flags), WithArgs(tst.args), WithEnv("CNT_"), WithEnvLookup(envMap(tst.env)), WithSources(SourceFunc(func(interface{}) error { return nil }))).Load(mm)
+			if tst.err != "" {
+				if err == nil || !strings.HasSuffix(err.Error(), tst.err) {
+					t.Fatalf("expected error ending in '%s', got: %v", tst.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loading: %v", err)
+			}
+			if *mm != tst.exp {
+				t.Errorf("unexpected values: %+v", *mm)
+			}
+		})
+	}
+
+	type badMain struct {
+		Verbose bool `count:"true"`
+	}
+	err := Flags(flag.NewFlagSet("", flag.ContinueOnError), &badMain{})
+	if err == nil || err.Error() != "count tag on non-integer field 'verbose'" {
+		t.Errorf("expected error for non-integer count, got: %v", err)
+	}
+}
+
+type noOptMain struct {
+	Color string `noopt:"always" short:"c"`
+	Level int    `noopt:"3"`
+	Name  string
+}
+
+func TestNoOpt(t *testing.T) {
+	tests := []struct {
+		args []string
+		exp  noOptMain
+	}{
+		{args: []string{}, exp: noOptMain{Color: "auto"}},
+		{args: []string{"--color", "--name", "x"}, exp: noOptMain{Color: "always", Name: "x"}},
+		{args: []string{"--color=never", "--level"}, exp: noOptMain{Color: "never", Level: 3}},
+		{args: []string{"-c", "--level=1"}, exp: noOptMain{Color: "always", Level: 1}},
+	}
+	for name, newFlagger := range testFlaggers() {
+		for _, tst := range tests {
+			t.Run(name+" "+strings.Join(tst.args, " "), func(t *testing.T) {
+				mm := &noOptMain{Color: "auto"}
+				err := LoadArgsEnv(newFlagger(), mm, tst.args, "", nil)
+				if err != nil {
+					t.Fatalf("loading: %v", err)
+				}
+				if *mm != tst.exp {
+					t.Errorf("unexpected values: %+v", *mm)
+				}
+			})
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.SetOutput(buf)
+	if err := Flags(fs, &noOptMain{}); err != nil {
+		t.Fatalf("setting up flags: %v", err)
+	}
+	if f := fs.Lookup("color"); f.Usage != "(a value must be given as -color=value)" {
+		t.Errorf("unexpected usage text for color: %s", f.Usage)
+	}
+	fs.Usage()
+	if !strings.Contains(buf.String(), "  -c, -color string[=\"always\"]\n    \t(a value must be given as -color=value)\n") || !strings.Contains(buf.String(), "  -level int[=3]\n") {
+		t.Errorf("unexpected usage:\n%s", buf)
+	}
+	buf.Reset()
+	pfs := pflag.NewFlagSet("", pflag.ContinueOnError)
+	if err := New(WithFlagSet(pfs), WithOutput(buf, buf)).Flags(&noOptMain{}); err != nil {
+		t.Fatalf("setting up flags: %v", err)
+	}
+	pfs.Usage()
+	if !strings.Contains(buf.String(), "  -c, --color string[=\"always\"]\n    \t\n") {
+		t.Errorf("unexpected usage:\n%s", buf)
+	}
+
+	type emptyMain struct {
+		Color string `noopt:""`
+	}
+	err := Flags(flag.NewFlagSet("", flag.ContinueOnError), &emptyMain{})
+	if err == nil || err.Error() != "noopt tag for 'color' is empty" {
+		t.Errorf("expected error for empty noopt, got: %v", err)
+	}
+}
+
+// level is a flag.Value without a Type method.
+type level int
+
+func (l *level) String() string { return [...]string{"low", "high"}[*l] }
+
+func (l *level) Set(s string) error {
+	switch s {
+	case "low":
+		*l = 0
+	case "high":
+		*l = 1
+	default:
+		return fmt.Errorf("unknown level '%s'", s)
+	}
+	return nil
+}
+
+// hostPort is a Value, and a struct which would otherwise be nested.
+type hostPort struct {
+	Host string
+	Port string
+}
+
+func (h *hostPort) String() string { return h.Host + ":" + h.Port }
+
+func (h *hostPort) Set(s string) error {
+	host, port, ok := strings.Cut(s, ":")
+	if !ok {
+		return fmt.Errorf("no port in '%s'", s)
+	}
+	h.Host, h.Port = host, port
+	return nil
+}
+
+func (h *hostPort) Type() string { return "host:port" }
+
+// toggle is a bool-like flag.Value.
+type toggle string
+
+func (t *toggle) String() string     { return string(*t) }
+func (t *toggle) Set(s string) error { *t = toggle("set to " + s); return nil }
+func (t *toggle) IsBoolFlag() bool   { return true }
+
+type valueMain struct {
+	Level  level
+	Addr   hostPort
+	Toggle toggle
+}
+
+func TestValueFields(t *testing.T) {
+	for name, newFlagger := range testFlaggers() {
+		t.Run(name, func(t *testing.T) {
+			mm := &valueMain{Addr: hostPort{"localhost", "80"}}
+			buf := &bytes.Buffer{}
+			com := New(WithFlagSet(newFlagger()), WithArgs([]string{"--level", "high", "--toggle"}), WithOutput(buf, buf))
+			err := com.Load(mm)
+			if err != nil {
+				t.Fatalf("loading: %v", err)
+			}
+			if mm.Level != 1 || mm.Addr != (hostPort{"localhost", "80"}) || mm.Toggle != "set to true" {
+				t.Errorf("unexpected values: %+v", mm)
+			}
+			com.fTr.writeUsage(buf, template.Must(template.New("").Funcs(usageFuncs(false)).Parse(DefaultUsageTemplate)), mm)
+			for _, line := range []string{"-level level\n", "-addr host:port\n", "(default localhost:80)", "-toggle\n"} {
+				if !strings.Contains(buf.String(), line) {
+					t.Errorf("usage doesn't contain %q:\n%s", line, buf)
+				}
+			}
+		})
+	}
+}
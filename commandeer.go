@@ -0,0 +1,522 @@
+package commandeer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Commandeer holds everything needed to set up flags for a struct, load their
+// values from args, the environment, and other sources, and run it. Create one
+// with New. The package level functions (Run, Flags, LoadArgsEnv, etc.) are
+// shorthand for using a Commandeer with the corresponding options.
+type Commandeer struct {
+	flags           Flagger
+	args            []string
+	env             bool
+	envPrefix       string
+	lookupEnv       func(key string) (string, bool)
+	secretFilesOnly bool
+	naming          NameStrategy
+	separator       string
+	sources         []Source
+	usageTemplate   string
+	stdout          io.Writer
+	stderr          io.Writer
+	exit            func(code int)
+
+	// argsErr describes errors parsing args, and differs for RunArgs for
+	// compatibility.
+	argsErr string
+
+	main interface{}
+	fTr  *flagTracker
+}
+
+// Option configures a Commandeer.
+type Option func(c *Commandeer)
+
+// New creates a Commandeer. By default it uses the command line flag set and
+// args, doesn't load anything from the environment, and returns errors rather
+// than exiting.
+func New(opts ...Option) *Commandeer {
+	c := &Commandeer{
+		flags:     &flagSet{flag.CommandLine},
+		args:      os.Args[1:],
+		lookupEnv: os.LookupEnv,
+		argsErr:   "parsing command line args",
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// WithFlagSet sets the Flagger flags will be set up on (usually an instance of
+// flag.FlagSet, pflag.FlagSet, or GNUFlagSet).
+func WithFlagSet(flags Flagger) Option {
+	return func(c *Commandeer) {
+		c.flags = flags
+	}
+}
+
+// WithArgs sets the args to be parsed.
+func WithArgs(args []string) Option {
+	return func(c *Commandeer) {
+		c.args = args
+	}
+}
+
+// WithEnv turns on loading flags from environment variables starting with
+// prefix. See LoadArgsEnv for how variable names are determined.
+func WithEnv(prefix string) Option {
+	return func(c *Commandeer) {
+		c.env, c.envPrefix = true, prefix
+	}
+}
+
+// WithEnvLookup replaces os.LookupEnv for getting environment variables.
+func WithEnvLookup(lookupEnv func(key string) (string, bool)) Option {
+	return func(c *Commandeer) {
+		c.lookupEnv = lookupEnv
+	}
+}
+
+// WithSecretFilesOnly restricts reading flag values from the files named by
+// "<NAME>_FILE" environment variables to secret fields (see Secret). It
+// defaults to false.
+func WithSecretFilesOnly(secretFilesOnly bool) Option {
+	return func(c *Commandeer) {
+		c.secretFilesOnly = secretFilesOnly
+	}
+}
+
+// WithNaming sets the NameStrategy for deriving flag names from field names.
+// It defaults to KebabCase.
+func WithNaming(naming NameStrategy) Option {
+	return func(c *Commandeer) {
+		c.naming = naming
+	}
+}
+
+// WithSeparator sets the string which joins nested struct flag names to their
+// fields' flag names. It defaults to ".".
+func WithSeparator(separator string) Option {
+	return func(c *Commandeer) {
+		c.separator = separator
+	}
+}
+
+// WithSources adds sources of configuration which take precedence over the
+// defaults, but not over the environment or args. Later sources take
+// precedence over earlier ones.
+func WithSources(sources ...Source) Option {
+	return func(c *Commandeer) {
+		c.sources = append(c.sources, sources...)
+	}
+}
+
+// WithUsageTemplate replaces DefaultUsageTemplate (and takes precedence over
+// UsageTemplater).
+func WithUsageTemplate(text string) Option {
+	return func(c *Commandeer) {
+		c.usageTemplate = text
+	}
+}
+
+// WithOutput sets where output goes. Usage requested with -h or --help is
+// written to stdout, while usage printed because of a parsing error and any
+// other output from the flag set is written to stderr, as are errors if
+// WithExit is used.
+func WithOutput(stdout, stderr io.Writer) Option {
+	return func(c *Commandeer) {
+		c.stdout, c.stderr = stdout, stderr
+	}
+}
+
+// WithExit makes Run and Load print errors and call exit (usually os.Exit)
+// rather than returning them. The code is 0 if help was requested, 2 if args
+// or other configuration couldn't be loaded, and 1 if Run returned an error.
+func WithExit(exit func(code int)) Option {
+	return func(c *Commandeer) {
+		c.exit = exit
+	}
+}
+
+// Flags sets up flags for main, which must be a pointer to a struct. See the
+// package level Flags function for how struct fields and tags are used.
+func (c *Commandeer) Flags(main interface{}) error {
+	fTr := newFlagTracker(c.flags)
+	fTr.env, fTr.envPrefix = c.env, c.envPrefix
+	fTr.getenv = c.lookupEnv
+	fTr.secretFilesOnly = c.secretFilesOnly
+	if c.naming != nil {
+		fTr.naming = c.naming
+	}
+	if c.separator != "" {
+		fTr.separator = c.separator
+	}
+	fTr.usageTemplate = c.usageTemplate
+	fTr.out = c.stderr
+	if c.stderr != nil {
+		if setter, ok := c.flags.(interface{ SetOutput(io.Writer) }); ok {
+			setter.SetOutput(c.stderr)
+		}
+	}
+	err := fTr.setup(main)
+	if err != nil {
+		return err
+	}
+	c.main, c.fTr = main, fTr
+	return nil
+}
+
+// Load sets up flags for main (unless Flags has already been called with it),
+// then sets their values. Args take the highest precedence, followed by the
+// environment (if WithEnv is used), followed by sources, followed by the
+// defaults (the values in main when Load is called). Args and the environment
+// are loaded before sources as well so that they can configure the sources
+// (e.g. with a path to a config file).
+func (c *Commandeer) Load(main interface{}) error {
+	return c.exitOnError(c.load(main), 2)
+}
+
+func (c *Commandeer) load(main interface{}) error {
+	if c.fTr == nil || c.main != main {
+		err := c.Flags(main)
+		if err != nil {
+			return fmt.Errorf("calling Flags: %v", err)
+		}
+	}
+	c.fTr.negate()
+	for _, t := range c.fTr.trackers(true) {
+		for _, counter := range t.counters {
+			counter.bareTrue = false // parse rewrites bare count flags
+		}
+	}
+	c.fTr.hidden = nil
+	err := c.loadValues(main)
+	if err != nil {
+		return c.fTr.maskErr(err)
+	}
+	err = c.fTr.applyImpls()
+	if err != nil {
+		return c.fTr.maskErr(fmt.Errorf("setting implementations: %v", err))
+	}
+	err = c.fTr.validateStructSlices()
+	if err != nil {
+		return fmt.Errorf("validating: %v", err)
+	}
+	err = c.fTr.checkPaths()
+	if err != nil {
+		return fmt.Errorf("checking paths: %v", err)
+	}
+	err = c.fTr.openFiles()
+	if err != nil {
+		return fmt.Errorf("opening files: %v", err)
+	}
+	return nil
+}
+
+// loadValues sets values from each place they can come from, in order of
+// increasing precedence.
+func (c *Commandeer) loadValues(main interface{}) error {
+	// set values based on environment
+	err := c.loadEnv()
+	if err != nil {
+		return fmt.Errorf("loading environment: %v", err)
+	}
+	// set values based on command line
+	err = c.parse()
+	if err != nil {
+		return fmt.Errorf("%s: %w", c.argsErr, err)
+	}
+	if len(c.sources) == 0 {
+		return nil
+	}
+	// set values from other sources
+	c.fTr.resetRepeated()
+	for _, source := range c.sources {
+		c.fTr.pathBase = sourceDir(source)
+		err = source.Load(main, c.set)
+		c.fTr.pathBase = ""
+		if err != nil {
+			return fmt.Errorf("loading source: %v", err)
+		}
+	}
+	// reset values with environment (precedence over sources)
+	err = c.loadEnv()
+	if err != nil {
+		return fmt.Errorf("reloading environment: %v", err)
+	}
+	// reset values with command line args (highest precedence)
+	err = c.parse()
+	if err != nil {
+		return fmt.Errorf("re%s: %w", c.argsErr, err)
+	}
+	return nil
+}
+
+// Run loads main (see Load), and then calls its Run method. main must
+// implement Runner. Files opened for main's fields are closed after its Run
+// method returns (or panics).
+func (c *Commandeer) Run(main interface{}) error {
+	err := c.load(main)
+	if err != nil {
+		return c.exitOnError(err, 2)
+	}
+	runner, ok := main.(Runner)
+	if !ok {
+		c.Close()
+		return c.exitOnError(fmt.Errorf("called 'Run' with something which doesn't implement the 'Run() error' method."), 1)
+	}
+	return c.exitOnError(c.run(runner), 1)
+}
+
+// run calls runner's Run method, then closes any files which were opened for
+// it, even if it panics.
+func (c *Commandeer) run(runner Runner) (err error) {
+	defer func() {
+		if closeErr := c.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	return runner.Run()
+}
+
+// Close closes any files Load opened for io.Reader, io.Writer, etc. fields.
+// Run calls it after main's Run method returns, so it's only needed when using
+// Load.
+func (c *Commandeer) Close() error {
+	if c.fTr == nil {
+		return nil
+	}
+	err := c.fTr.closeFiles()
+	if err != nil {
+		return fmt.Errorf("closing files: %v", err)
+	}
+	return nil
+}
+
+// WriteUsage writes usage for the struct Flags (or Load or Run) set up flags
+// for to w, using the same template -h would. Along with
+// WithUsageTemplate(MarkdownUsageTemplate), it can generate documentation for
+// a command's flags and environment variables.
+func (c *Commandeer) WriteUsage(w io.Writer) error {
+	if c.fTr == nil {
+		return fmt.Errorf("flags haven't been set up")
+	}
+	tmpl, err := c.fTr.template(c.main)
+	if err != nil {
+		return err
+	}
+	return c.fTr.writeUsage(w, tmpl, c.main)
+}
+
+func (c *Commandeer) loadEnv() error {
+	if !c.env {
+		return nil
+	}
+	return c.fTr.loadEnv()
+}
+
+// parse parses the args. If usage is printed while parsing, it goes to stdout
+// if help was requested, and stderr otherwise.
+func (c *Commandeer) parse() error {
+	c.fTr.resetRepeated()
+	args := c.fTr.bareArgs(c.fTr.indexedArgs(c.args))
+	if c.stdout == nil {
+		return c.fTr.indexedErr(c.flags.Parse(args))
+	}
+	buf := &bytes.Buffer{}
+	c.fTr.out = buf
+	err := c.flags.Parse(args)
+	c.fTr.out = c.stderr
+	if isHelp(err) {
+		buf.WriteTo(c.stdout)
+	} else {
+		buf.WriteTo(c.fTr.output())
+	}
+	return c.fTr.indexedErr(err)
+}
+
+// set sets the flag for key, which is joined with the separator. If there's no
+// such flag, but the key without its last part is a map flag, the last part is
+// used as the map key.
+func (c *Commandeer) set(key []string, value string) error {
+	name := strings.Join(key, c.fTr.separator)
+	var err error
+	if setter, ok := c.mapSetter(key); ok {
+		err = setter.SetKey(key[len(key)-1], value)
+	} else {
+		err = c.flags.Set(name, value)
+	}
+	if err != nil {
+		if info, ok := c.fTr.infos[name]; ok && info.secret {
+			value = mask
+		}
+		return fmt.Errorf("couldn't set %s to %s: %v", name, value, err)
+	}
+	return nil
+}
+
+// mapSetter gets the Value of the map flag key is an entry of, if any.
+func (c *Commandeer) mapSetter(key []string) (interface{ SetKey(key, value string) error }, bool) {
+	if len(key) < 2 {
+		return nil, false
+	}
+	if _, ok := c.fTr.lookup(strings.Join(key, c.fTr.separator)); ok {
+		return nil, false
+	}
+	f, ok := c.fTr.lookup(strings.Join(key[:len(key)-1], c.fTr.separator))
+	if !ok {
+		return nil, false
+	}
+	setter, ok := f.Value.(interface{ SetKey(key, value string) error })
+	return setter, ok
+}
+
+// exitOnError prints err and exits with code if WithExit was used, and returns
+// err otherwise.
+func (c *Commandeer) exitOnError(err error, code int) error {
+	if err == nil || c.exit == nil {
+		return err
+	}
+	if isHelp(err) {
+		c.exit(0)
+		return err
+	}
+	stderr := c.stderr
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+	fmt.Fprintln(stderr, err)
+	c.exit(code)
+	return err
+}
+
+// isHelp reports whether err is (or wraps) the flag or pflag help error.
+// pflag's is recognized by its message so that pflag isn't a dependency.
+func isHelp(err error) bool {
+	for ; err != nil; err = errors.Unwrap(err) {
+		if err == flag.ErrHelp || err.Error() == "pflag: help requested" {
+			return true
+		}
+	}
+	return false
+}
+
+// Source is a source of configuration other than args and the environment,
+// such as a config file.
+type Source interface {
+	// Load sets values on main. It may set them directly, or call set with
+	// the key for a flag (which will be joined with the separator, e.g.
+	// ["server", "port"] for "server.port") and its value as text, which
+	// will be parsed as it would be from the command line.
+	//
+	// If a Source has a "Dir() string" method, relative paths it sets for
+	// path flags are resolved against that directory.
+	Load(main interface{}, set func(key []string, value string) error) error
+}
+
+// SourceFunc is a Source which operates on main directly, like the
+// configElsewhere func passed to LoadArgsEnv.
+type SourceFunc func(main interface{}) error
+
+// Load calls f with main.
+func (f SourceFunc) Load(main interface{}, set func(key []string, value string) error) error {
+	return f(main)
+}
+
+// JSONFile is a Source which reads a JSON object from the file at the given
+// path. Nested objects are used for nested structs, and arrays are joined with
+// commas (e.g. for string slices), so that {"server": {"port": 80}} sets
+// "server.port" to "80".
+type JSONFile string
+
+// Load reads the file and sets the flag for each key.
+func (path JSONFile) Load(main interface{}, set func(key []string, value string) error) error {
+	dat, err := os.ReadFile(string(path))
+	if err != nil {
+		return err
+	}
+	obj := make(map[string]interface{})
+	dec := json.NewDecoder(bytes.NewReader(dat))
+	dec.UseNumber()
+	err = dec.Decode(&obj)
+	if err != nil {
+		return fmt.Errorf("decoding %s: %v", path, err)
+	}
+	return setJSON(nil, obj, set)
+}
+
+// Dir gets the directory the file is in, which relative paths in it are
+// resolved against.
+func (path JSONFile) Dir() string {
+	return filepath.Dir(string(path))
+}
+
+// setJSON calls set for every value in obj, with keys prefixed by key.
+func setJSON(key []string, obj map[string]interface{}, set func(key []string, value string) error) error {
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		subkey := append(key[:len(key):len(key)], k)
+		switch v := obj[k].(type) {
+		case nil:
+			continue
+		case map[string]interface{}:
+			if err := setJSON(subkey, v, set); err != nil {
+				return err
+			}
+			continue
+		case []interface{}:
+			if len(v) > 0 {
+				if _, ok := v[0].(map[string]interface{}); ok {
+					// arrays of objects are for slices of structs,
+					// which parse them as JSON
+					if err := set(subkey, jsonText(v)); err != nil {
+						return err
+					}
+					continue
+				}
+			}
+			elems := make([]string, len(v))
+			for i, elem := range v {
+				elems[i] = jsonText(elem)
+			}
+			if err := set(subkey, strings.Join(elems, ",")); err != nil {
+				return err
+			}
+		default:
+			if err := set(subkey, jsonText(v)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// jsonText converts a decoded JSON value to the text a flag would parse.
+func jsonText(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case map[string]interface{}, []interface{}:
+		dat, _ := json.Marshal(v)
+		return string(dat)
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,224 @@
+package commandeer
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jaffee/commandeer/test"
+	"github.com/spf13/pflag"
+)
+
+type sourceMain struct {
+	Name   string
+	Port   int
+	Tags   []string
+	Server struct {
+		Host    string
+		Timeout int
+	}
+	Config string
+}
+
+func (m *sourceMain) Run() error {
+	if m.Port == 0 {
+		return fmt.Errorf("need a port")
+	}
+	return nil
+}
+
+func envMap(env map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		val, ok := env[key]
+		return val, ok
+	}
+}
+
+func TestCommandeerLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := dir + "/config.json"
+	err := os.WriteFile(path, []byte(`{"name": "file", "port": 1, "tags": ["a", "b"], "server": {"host": "filehost", "timeout": 30}}`), 0600)
+	if err != nil {
+		t.Fatalf("writing config: %v", err)
+	}
+
+	mm := &sourceMain{}
+	com := New(
+		WithFlagSet(flag.NewFlagSet("tst", flag.ContinueOnError)),
+		WithArgs([]string{"-port", "3"}),
+		WithEnv("APP_"),
+		WithEnvLookup(envMap(map[string]string{"APP_SERVER_HOST": "envhost", "APP_CONFIG": path})),
+		WithSources(SourceFunc(func(main interface{}) error {
+			// sources can use values from args and env
+			m := main.(*sourceMain)
+			if m.Config != path || m.Port != 3 {
+				return fmt.Errorf("args and env not loaded before source")
+			}
+			m.Name, m.Port = "func", 4
+			return nil
+		})),
+	)
+	err = com.Load(mm)
+	if err != nil {
+		t.Fatalf("loading: %v", err)
+	}
+	if mm.Name != "func" || mm.Port != 3 {
+		t.Errorf("unexpected values: %+v", mm)
+	}
+
+	mm = &sourceMain{}
+	com = New(
+		WithFlagSet(flag.NewFlagSet("tst", flag.ContinueOnError)),
+		WithArgs([]string{"-port", "3"}),
+		WithEnv("APP_"),
+		WithEnvLookup(envMap(map[string]string{"APP_SERVER_HOST": "envhost"})),
+		WithSources(JSONFile(path)),
+	)
+	err = com.Load(mm)
+	if err != nil {
+		t.Fatalf("loading: %v", err)
+	}
+	if mm.Name != "file" || mm.Port != 3 || mm.Server.Host != "envhost" || mm.Server.Timeout != 30 {
+		t.Errorf("unexpected values: %+v", mm)
+	}
+	if !reflect.DeepEqual(mm.Tags, []string{"a", "b"}) {
+		t.Errorf("unexpected tags: %v", mm.Tags)
+	}
+}
+
+func TestCommandeerSourceSeparator(t *testing.T) {
+	dir := t.TempDir()
+	path := dir + "/config.json"
+	err := os.WriteFile(path, []byte(`{"server": {"host": "filehost"}}`), 0600)
+	if err != nil {
+		t.Fatalf("writing config: %v", err)
+	}
+	mm := &sourceMain{}
+	fs := flag.NewFlagSet("tst", flag.ContinueOnError)
+	err = New(WithFlagSet(fs), WithArgs(nil), WithSeparator("_"), WithNaming(SnakeCase), WithSources(JSONFile(path))).Load(mm)
+	if err != nil {
+		t.Fatalf("loading: %v", err)
+	}
+	if mm.Server.Host != "filehost" || fs.Lookup("server_host") == nil {
+		t.Errorf("unexpected host: %s", mm.Server.Host)
+	}
+
+	err = os.WriteFile(path, []byte(`{"nope": 1}`), 0600)
+	if err != nil {
+		t.Fatalf("writing config: %v", err)
+	}
+	err = New(WithFlagSet(flag.NewFlagSet("tst", flag.ContinueOnError)), WithArgs(nil), WithSources(JSONFile(path))).Load(&sourceMain{})
+	if err == nil || !strings.Contains(err.Error(), "couldn't set nope to 1") {
+		t.Errorf("expected error for unknown key, got: %v", err)
+	}
+}
+
+func TestCommandeerOutput(t *testing.T) {
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	err := New(
+		WithFlagSet(flag.NewFlagSet("tst", flag.ContinueOnError)),
+		WithArgs([]string{"-h"}),
+		WithOutput(stdout, stderr),
+	).Run(&sourceMain{})
+	if !isHelp(err) {
+		t.Fatalf("expected help error, got: %v", err)
+	}
+	if !strings.HasPrefix(stdout.String(), "Usage of tst:") || stderr.Len() != 0 {
+		t.Errorf("help should go to stdout. stdout:\n%s\nstderr:\n%s", stdout, stderr)
+	}
+
+	stdout.Reset()
+	err = New(
+		WithFlagSet(pflag.NewFlagSet("tst", pflag.ContinueOnError)),
+		WithArgs([]string{"--nope"}),
+		WithOutput(stdout, stderr),
+	).Run(&sourceMain{})
+	if err == nil || isHelp(err) {
+		t.Fatalf("expected parsing error, got: %v", err)
+	}
+	if stdout.Len() != 0 {
+		t.Errorf("nothing should go to stdout, got:\n%s", stdout)
+	}
+
+	stdout.Reset()
+	err = New(
+		WithFlagSet(flag.NewFlagSet("tst", flag.ContinueOnError)),
+		WithArgs([]string{"-nope"}),
+		WithOutput(stdout, stderr),
+	).Run(&sourceMain{})
+	if err == nil || isHelp(err) {
+		t.Fatalf("expected parsing error, got: %v", err)
+	}
+	if stdout.Len() != 0 || !strings.HasPrefix(stderr.String(), "flag provided but not defined: -nope\nUsage of tst:") {
+		t.Errorf("errors should go to stderr. stdout:\n%s\nstderr:\n%s", stdout, stderr)
+	}
+}
+
+func TestCommandeerExit(t *testing.T) {
+	tests := []struct {
+		args []string
+		code int
+		err  string
+	}{
+		{args: []string{"-h"}, code: 0},
+		{args: []string{"-nope"}, code: 2, err: "parsing command line args: flag provided but not defined: -nope"},
+		{args: []string{}, code: 1, err: "need a port"},
+		{args: []string{"-port", "2"}, code: -1},
+	}
+	for i, tst := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			stderr := &bytes.Buffer{}
+			code := -1
+			fs := flag.NewFlagSet("tst", flag.ContinueOnError)
+			fs.SetOutput(&bytes.Buffer{})
+			New(WithFlagSet(fs), WithArgs(tst.args), WithExit(func(c int) { code = c }), WithOutput(&bytes.Buffer{}, stderr)).Run(&sourceMain{})
+			if code != tst.code {
+				t.Errorf("expected exit code %d, got %d", tst.code, code)
+			}
+			if tst.err != "" && !strings.HasSuffix(stderr.String(), tst.err+"\n") {
+				t.Errorf("expected error '%s' at the end of stderr, got:\n%s", tst.err, stderr)
+			}
+		})
+	}
+}
+
+func TestHelpErrors(t *testing.T) {
+	fs := pflag.NewFlagSet("tst", pflag.ContinueOnError)
+	fs.SetOutput(&bytes.Buffer{})
+	err := New(WithFlagSet(fs), WithArgs([]string{"--help"})).Load(&sourceMain{})
+	if !errors.Is(err, pflag.ErrHelp) || !isHelp(err) {
+		t.Errorf("expected wrapped pflag help error, got: %v", err)
+	}
+	if isHelp(errors.New("no help requested")) {
+		t.Errorf("only the help errors should count as help")
+	}
+
+	ffs := flag.NewFlagSet("tst", flag.ContinueOnError)
+	ffs.SetOutput(&bytes.Buffer{})
+	err = RunArgs(ffs, &sourceMain{}, []string{"-nope"})
+	if err == nil || err.Error() != "parsing flags: flag provided but not defined: -nope" {
+		t.Errorf("unexpected error from RunArgs: %v", err)
+	}
+}
+
+func TestCommandeerFlagsThenLoad(t *testing.T) {
+	mm := test.NewSimpleMain()
+	fs := flag.NewFlagSet("tst", flag.ContinueOnError)
+	com := New(WithFlagSet(fs), WithArgs([]string{"-one", "uno"}))
+	err := com.Flags(mm)
+	if err != nil {
+		t.Fatalf("calling Flags: %v", err)
+	}
+	err = com.Load(mm)
+	if err != nil {
+		t.Fatalf("loading after Flags: %v", err)
+	}
+	if mm.One != "uno" {
+		t.Errorf("unexpected value for One: %s", mm.One)
+	}
+}
@@ -0,0 +1,208 @@
+package commandeer
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	day  = 24 * time.Hour
+	week = 7 * day
+)
+
+// durationUnits are the units allowed in extended durations, which are those
+// of time.ParseDuration plus days and weeks.
+var durationUnits = map[string]time.Duration{
+	"ns": time.Nanosecond,
+	"us": time.Microsecond,
+	"µs": time.Microsecond, // U+00B5
+	"μs": time.Microsecond, // U+03BC
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+	"d":  day,
+	"w":  week,
+}
+
+// isoDateUnits and isoTimeUnits are the units allowed before and after the
+// "T" in an ISO 8601 duration. Years and months are left out since they
+// aren't a fixed length.
+var (
+	isoDateUnits = map[string]time.Duration{"W": week, "D": day}
+	isoTimeUnits = map[string]time.Duration{"H": time.Hour, "M": time.Minute, "S": time.Second}
+)
+
+// extendedDurationValue is the Value for a time.Duration field with the
+// duration:"extended" tag.
+type extendedDurationValue struct {
+	value *time.Duration
+}
+
+func (v extendedDurationValue) Set(s string) error {
+	d, err := parseExtendedDuration(s)
+	if err != nil {
+		return err
+	}
+	*v.value = d
+	return nil
+}
+
+func (v extendedDurationValue) String() string {
+	if v.value == nil {
+		return "0s"
+	}
+	return formatExtendedDuration(*v.value)
+}
+
+func (v extendedDurationValue) Type() string { return "duration" }
+
+// parseExtendedDuration parses a duration like time.ParseDuration does, but
+// also allows days ("d") and weeks ("w"), as in "1w2d12h", or an ISO 8601
+// duration like "P7D" or "PT1H30M".
+func parseExtendedDuration(s string) (time.Duration, error) {
+	orig := s
+	neg := false
+	if s != "" && (s[0] == '-' || s[0] == '+') {
+		neg = s[0] == '-'
+		s = s[1:]
+	}
+	var d time.Duration
+	var err error
+	switch {
+	case s == "0":
+	case strings.HasPrefix(s, "P") || strings.HasPrefix(s, "p"):
+		d, err = parseISODuration(strings.ToUpper(s[1:]))
+	default:
+		d, err = sumDuration(s, durationUnits)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration '%s': %v (expected e.g. 1h30m, 7d, 2w, or P7D)", orig, err)
+	}
+	if neg {
+		d = -d
+	}
+	return d, nil
+}
+
+// parseISODuration parses the part of an ISO 8601 duration after the "P".
+func parseISODuration(s string) (time.Duration, error) {
+	date, clock, hasTime := strings.Cut(s, "T")
+	if date == "" && clock == "" {
+		return 0, fmt.Errorf("no amounts given")
+	}
+	if strings.ContainsAny(date, "YM") {
+		return 0, fmt.Errorf("years and months aren't supported")
+	}
+	if hasTime && clock == "" {
+		return 0, fmt.Errorf("no amounts given after 'T'")
+	}
+	var d, t time.Duration
+	var err error
+	if date != "" {
+		d, err = sumDuration(date, isoDateUnits)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if clock != "" {
+		t, err = sumDuration(clock, isoTimeUnits)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if d > math.MaxInt64-t {
+		return 0, strconv.ErrRange
+	}
+	return d + t, nil
+}
+
+// sumDuration adds up a sequence of numbers followed by units, e.g. "1d12h".
+func sumDuration(s string, units map[string]time.Duration) (time.Duration, error) {
+	if s == "" {
+		return 0, fmt.Errorf("no amounts given")
+	}
+	var total time.Duration
+	for s != "" {
+		i := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+		if i == 0 {
+			return 0, fmt.Errorf("expected a number at '%s'", s)
+		}
+		if i < 0 {
+			return 0, fmt.Errorf("missing unit after '%s'", s)
+		}
+		num := s[:i]
+		s = s[i:]
+		j := strings.IndexFunc(s, func(r rune) bool { return (r >= '0' && r <= '9') || r == '.' })
+		if j < 0 {
+			j = len(s)
+		}
+		unit, ok := units[s[:j]]
+		if !ok {
+			return 0, fmt.Errorf("unknown unit '%s'", s[:j])
+		}
+		s = s[j:]
+
+		var d time.Duration
+		if n, err := strconv.ParseInt(num, 10, 64); err == nil {
+			if n > math.MaxInt64/int64(unit) {
+				return 0, strconv.ErrRange
+			}
+			d = time.Duration(n) * unit
+		} else {
+			f, err := strconv.ParseFloat(num, 64)
+			if err != nil {
+				return 0, numError(err)
+			}
+			f = math.Round(f * float64(unit))
+			if f >= math.MaxInt64 {
+				return 0, strconv.ErrRange
+			}
+			d = time.Duration(f)
+		}
+		if total > math.MaxInt64-d {
+			return 0, strconv.ErrRange
+		}
+		total += d
+	}
+	return total, nil
+}
+
+// formatExtendedDuration formats d with weeks (if it's a whole number of
+// them) or days, followed by the rest as time.Duration would format it but
+// without zero minutes and seconds, e.g. "2w", "30d", "1d12h", or "1h30m".
+func formatExtendedDuration(d time.Duration) string {
+	if d == 0 {
+		return "0s"
+	}
+	sign := ""
+	if d < 0 {
+		if d == math.MinInt64 {
+			return d.String()
+		}
+		sign = "-"
+		d = -d
+	}
+	if d%week == 0 {
+		return sign + strconv.FormatInt(int64(d/week), 10) + "w"
+	}
+	days, rest := d/day, d%day
+	s := ""
+	if days > 0 {
+		s = strconv.FormatInt(int64(days), 10) + "d"
+	}
+	if rest > 0 {
+		r := rest.String()
+		if strings.HasSuffix(r, "m0s") {
+			r = strings.TrimSuffix(r, "0s")
+		}
+		if strings.HasSuffix(r, "h0m") {
+			r = strings.TrimSuffix(r, "0m")
+		}
+		s += r
+	}
+	return sign + s
+}
@@ -0,0 +1,119 @@
+package commandeer
+
+import (
+	"bytes"
+	"flag"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseExtendedDuration(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+		err  string
+	}{
+		{in: "0", want: 0},
+		{in: "720h", want: 720 * time.Hour},
+		{in: "1h30m", want: 90 * time.Minute},
+		{in: "7d", want: 7 * day},
+		{in: "2w", want: 2 * week},
+		{in: "1w2d12h", want: 9*day + 12*time.Hour},
+		{in: "1.5d", want: 36 * time.Hour},
+		{in: "-3d", want: -3 * day},
+		{in: "250ms", want: 250 * time.Millisecond},
+		{in: "P7D", want: 7 * day},
+		{in: "P1W", want: week},
+		{in: "P1DT12H", want: 36 * time.Hour},
+		{in: "PT1H30M", want: 90 * time.Minute},
+		{in: "PT0.5S", want: 500 * time.Millisecond},
+		{in: "pt10m", want: 10 * time.Minute},
+		{in: "P1M", err: "years and months aren't supported"},
+		{in: "P", err: "no amounts given"},
+		{in: "P1DT", err: "no amounts given after 'T'"},
+		{in: "7", err: "missing unit"},
+		{in: "7y", err: "unknown unit 'y'"},
+		{in: "", err: "no amounts given"},
+		{in: "100000000w", err: "out of range"},
+	}
+	for _, tst := range tests {
+		got, err := parseExtendedDuration(tst.in)
+		if tst.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tst.err) {
+				t.Errorf("parsing '%s': expected error containing '%s', got %v, %v", tst.in, tst.err, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tst.want {
+			t.Errorf("parsing '%s': expected %v, got %v, %v", tst.in, tst.want, got, err)
+		}
+	}
+}
+
+func TestFormatExtendedDuration(t *testing.T) {
+	for d, want := range map[time.Duration]string{
+		0:                            "0s",
+		720 * time.Hour:              "30d",
+		2 * week:                     "2w",
+		36 * time.Hour:               "1d12h",
+		90 * time.Minute:             "1h30m",
+		time.Hour:                    "1h",
+		10 * time.Second:             "10s",
+		2*time.Hour + 10*time.Second: "2h0m10s",
+		-3 * day:                     "-3d",
+		1500 * time.Millisecond:      "1.5s",
+	} {
+		if got := formatExtendedDuration(d); got != want {
+			t.Errorf("formatting %v: expected '%s', got '%s'", time.Duration(d), want, got)
+		}
+		if back, err := parseExtendedDuration(formatExtendedDuration(d)); err != nil || back != d {
+			t.Errorf("round trip of %v: got %v, %v", time.Duration(d), back, err)
+		}
+	}
+}
+
+type durationMain struct {
+	Retention time.Duration `duration:"extended" help:"how long to keep data"`
+	Timeout   time.Duration
+	Interval  time.Duration `duration:"extended"`
+}
+
+func TestExtendedDurationFlags(t *testing.T) {
+	for name, newFlagger := range testFlaggers() {
+		t.Run(name, func(t *testing.T) {
+			mm := &durationMain{}
+			mustSetenv(t, "DUR_INTERVAL", "P1DT6H")
+			err := LoadArgsEnv(newFlagger(), mm, []string{"--retention", "2w", "--timeout=30s"}, "DUR_", nil)
+			if err != nil {
+				t.Fatalf("loading: %v", err)
+			}
+			if mm.Retention != 2*week || mm.Timeout != 30*time.Second || mm.Interval != 30*time.Hour {
+				t.Errorf("unexpected durations: %+v", mm)
+			}
+		})
+	}
+
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.SetOutput(&bytes.Buffer{})
+	err := Flags(fs, &durationMain{Retention: 720 * time.Hour, Timeout: 720 * time.Hour})
+	if err != nil {
+		t.Fatalf("setting up flags: %v", err)
+	}
+	for name, def := range map[string]string{"retention": "30d", "timeout": "720h0m0s"} {
+		if got := fs.Lookup(name).DefValue; got != def {
+			t.Errorf("unexpected default for %s: '%s'", name, got)
+		}
+	}
+	err = fs.Parse([]string{"-timeout=7d"})
+	if err == nil {
+		t.Errorf("expected error for days without the extended tag")
+	}
+
+	err = Flags(flag.NewFlagSet("", flag.ContinueOnError), &struct {
+		Wait time.Duration `duration:"iso"`
+	}{})
+	if err == nil || !strings.Contains(err.Error(), "unknown duration format 'iso'") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,154 @@
+package commandeer
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+)
+
+var (
+	readerType      = reflect.TypeOf((*io.Reader)(nil)).Elem()
+	readCloserType  = reflect.TypeOf((*io.ReadCloser)(nil)).Elem()
+	writerType      = reflect.TypeOf((*io.Writer)(nil)).Elem()
+	writeCloserType = reflect.TypeOf((*io.WriteCloser)(nil)).Elem()
+	fileType        = reflect.TypeOf((*os.File)(nil))
+)
+
+// fileModes maps the values of the "mode" tag to flags for os.OpenFile.
+var fileModes = map[string]int{
+	"truncate": os.O_WRONLY | os.O_CREATE | os.O_TRUNC,
+	"append":   os.O_WRONLY | os.O_CREATE | os.O_APPEND,
+	"create":   os.O_WRONLY | os.O_CREATE | os.O_EXCL,
+}
+
+// isFileField reports whether a field of typ is opened from a path.
+func isFileField(typ reflect.Type) bool {
+	switch typ {
+	case readerType, readCloserType, writerType, writeCloserType, fileType:
+		return true
+	}
+	return false
+}
+
+// fileValue is the Value for an io.Reader, io.ReadCloser, io.Writer,
+// io.WriteCloser, or *os.File field. Set only records the path, since it may
+// be set more than once while loading; the file is opened once loading is
+// done, and closed by Commandeer.Close.
+type fileValue struct {
+	name  string
+	value reflect.Value
+	// flag is for os.OpenFile, and is os.O_RDONLY for files which are read.
+	flag int
+	path string
+	file *os.File
+}
+
+func (v *fileValue) Set(s string) error {
+	v.path = s
+	return nil
+}
+
+func (v *fileValue) String() string {
+	if v == nil {
+		return ""
+	}
+	if v.path != "" {
+		return v.path
+	}
+	if v.value.IsValid() && !v.value.IsNil() {
+		switch v.value.Interface() {
+		case os.Stdin, os.Stdout:
+			return "-"
+		}
+	}
+	return ""
+}
+
+func (v *fileValue) Type() string { return "file" }
+
+// open opens the file at the path which was set, if any, and sets the field to
+// it. "-" means stdin for files which are read and stdout otherwise.
+func (v *fileValue) open() error {
+	if v.path == "" {
+		return nil
+	}
+	f := os.Stdin
+	if v.flag != os.O_RDONLY {
+		f = os.Stdout
+	}
+	if v.path != "-" {
+		var err error
+		f, err = os.OpenFile(v.path, v.flag, 0644)
+		if err != nil {
+			return err
+		}
+		v.file = f
+	}
+	v.value.Set(reflect.ValueOf(f))
+	return nil
+}
+
+// close closes the file if it was opened by open.
+func (v *fileValue) close() error {
+	if v.file == nil {
+		return nil
+	}
+	err := v.file.Close()
+	v.file = nil
+	return err
+}
+
+// newFileValue sets up the Value for a file field. Files are opened for
+// reading unless the field is a writer, or an *os.File with a "mode" tag.
+func newFileValue(ft reflect.StructField, f reflect.Value, flagName string) (*fileValue, error) {
+	mode, hasMode := ft.Tag.Lookup("mode")
+	write := ft.Type == writerType || ft.Type == writeCloserType || (ft.Type == fileType && hasMode)
+	if !write {
+		if hasMode {
+			return nil, fmt.Errorf("mode tag on '%v', which is only read", flagName)
+		}
+		return &fileValue{name: flagName, value: f, flag: os.O_RDONLY}, nil
+	}
+	if mode == "" {
+		mode = "truncate"
+	}
+	flag, ok := fileModes[mode]
+	if !ok {
+		return nil, fmt.Errorf("unknown mode '%s' for '%v', expected append, create, or truncate", mode, flagName)
+	}
+	return &fileValue{name: flagName, value: f, flag: flag}, nil
+}
+
+// openFiles opens every file flag once everything has been loaded, skipping
+// those of implementations which weren't selected. If one can't be opened,
+// the ones which were are closed again.
+func (fTr *flagTracker) openFiles() error {
+	for _, t := range fTr.trackers(false) {
+		for _, file := range t.files {
+			if err := file.close(); err != nil {
+				return fmt.Errorf("%s: %v", file.name, err)
+			}
+			if err := file.open(); err != nil {
+				fTr.closeFiles()
+				return fmt.Errorf("%s: %v", file.name, err)
+			}
+		}
+	}
+	fTr.assign()
+	return nil
+}
+
+// closeFiles closes every file opened for a file flag, returning the first
+// error.
+func (fTr *flagTracker) closeFiles() error {
+	var first error
+	for _, t := range fTr.trackers(true) {
+		for _, file := range t.files {
+			if err := file.close(); err != nil && first == nil {
+				first = fmt.Errorf("%s: %v", file.name, err)
+			}
+		}
+	}
+	return first
+}
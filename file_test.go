@@ -0,0 +1,158 @@
+package commandeer
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type fileMain struct {
+	In   io.Reader `help:"input file"`
+	Out  io.WriteCloser
+	Log  *os.File `mode:"append"`
+	Data *os.File
+
+	ran  bool
+	read string
+}
+
+func (m *fileMain) Run() error {
+	m.ran = true
+	dat, err := io.ReadAll(m.In)
+	if err != nil {
+		return err
+	}
+	m.read = string(dat)
+	_, err = io.WriteString(m.Out, strings.ToUpper(m.read))
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(m.Log, "ran\n")
+	return err
+}
+
+func TestFileFields(t *testing.T) {
+	dir := t.TempDir()
+	in, out, log := filepath.Join(dir, "in"), filepath.Join(dir, "out"), filepath.Join(dir, "log")
+	for path, dat := range map[string]string{in: "hello", out: "old contents", log: "before\n"} {
+		if err := os.WriteFile(path, []byte(dat), 0644); err != nil {
+			t.Fatalf("writing %s: %v", path, err)
+		}
+	}
+	for name, newFlagger := range testFlaggers() {
+		t.Run(name, func(t *testing.T) {
+			mm := &fileMain{}
+			err := New(WithFlagSet(newFlagger()), WithArgs([]string{"--in", in, "--out", out, "--log", log})).Run(mm)
+			if err != nil {
+				t.Fatalf("running: %v", err)
+			}
+			if !mm.ran || mm.read != "hello" || mm.Data != nil {
+				t.Errorf("unexpected main: %+v", mm)
+			}
+			if dat, _ := os.ReadFile(out); string(dat) != "HELLO" {
+				t.Errorf("expected out to be truncated and written, got '%s'", dat)
+			}
+			if _, err := mm.Out.Write([]byte("x")); err == nil {
+				t.Errorf("expected out to be closed after Run")
+			}
+		})
+	}
+	if dat, _ := os.ReadFile(log); string(dat) != "before\n"+strings.Repeat("ran\n", len(testFlaggers())) {
+		t.Errorf("expected log to be appended to, got '%s'", dat)
+	}
+
+	mm := &fileMain{}
+	com := New(WithFlagSet(flag.NewFlagSet("", flag.ContinueOnError)), WithArgs([]string{"-in", "-", "-out", "-", "-data", in}))
+	err := com.Load(mm)
+	if err != nil {
+		t.Fatalf("loading: %v", err)
+	}
+	if mm.In != os.Stdin || mm.Out != os.Stdout || mm.Data == nil || mm.Log != nil {
+		t.Errorf("unexpected files: %+v", mm)
+	}
+	err = com.Close()
+	if err != nil {
+		t.Fatalf("closing: %v", err)
+	}
+	if _, err := mm.Data.Stat(); err == nil {
+		t.Errorf("expected data to be closed")
+	}
+	if _, err := os.Stdout.Stat(); err != nil {
+		t.Errorf("expected stdout to be left open: %v", err)
+	}
+}
+
+// panicMain panics in Run after its file is opened.
+type panicMain struct {
+	Out io.Writer
+}
+
+func (m *panicMain) Run() error { panic("oops") }
+
+func TestFileClosedAfterPanic(t *testing.T) {
+	mm := &panicMain{}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("expected Run to panic")
+			}
+		}()
+		New(WithFlagSet(flag.NewFlagSet("", flag.ContinueOnError)), WithArgs([]string{"-out", filepath.Join(t.TempDir(), "out")})).Run(mm)
+	}()
+	if mm.Out == nil {
+		t.Fatalf("expected out to be opened")
+	}
+	if _, err := mm.Out.Write([]byte("x")); err == nil {
+		t.Errorf("expected out to be closed after Run panicked")
+	}
+}
+
+func TestFileFieldErrors(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing")
+	err := os.WriteFile(existing, nil, 0644)
+	if err != nil {
+		t.Fatalf("writing file: %v", err)
+	}
+	err = New(WithFlagSet(flag.NewFlagSet("", flag.ContinueOnError)), WithArgs([]string{"-in", filepath.Join(dir, "missing")})).Load(&fileMain{})
+	if err == nil || !strings.Contains(err.Error(), "opening files: in: open ") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	err = New(WithFlagSet(flag.NewFlagSet("", flag.ContinueOnError)), WithArgs([]string{"-out", existing})).Load(&struct {
+		Out io.Writer `mode:"create"`
+	}{})
+	if err == nil || !strings.Contains(err.Error(), "file exists") {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	for _, tst := range []struct {
+		main interface{}
+		err  string
+	}{
+		{main: &struct {
+			In io.Reader `mode:"append"`
+		}{}, err: "mode tag on 'in', which is only read"},
+		{main: &struct {
+			Out io.Writer `mode:"overwrite"`
+		}{}, err: "unknown mode 'overwrite' for 'out'"},
+	} {
+		err := Flags(flag.NewFlagSet("", flag.ContinueOnError), tst.main)
+		if err == nil || !strings.Contains(err.Error(), tst.err) {
+			t.Errorf("expected error containing '%s', got: %v", tst.err, err)
+		}
+	}
+
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.SetOutput(&bytes.Buffer{})
+	err = Flags(fs, &fileMain{In: os.Stdin})
+	if err != nil {
+		t.Fatalf("setting up flags: %v", err)
+	}
+	if def := fs.Lookup("in").DefValue; def != "-" {
+		t.Errorf("unexpected default: '%s'", def)
+	}
+}
@@ -0,0 +1,494 @@
+package commandeer
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// GNUFlag is a flag defined on a GNUFlagSet.
+type GNUFlag struct {
+	Name      string // name as it appears on the command line after "--"
+	Shorthand string // one letter name used after "-", or ""
+	Usage     string // help message
+	Value     Value  // value as set
+	DefValue  string // default value (as text) for the usage message
+	// NoOptDefVal is the value the flag is set to if it's given on the
+	// command line without one (e.g. "--verbose" rather than
+	// "--verbose=true"). Flags whose Value has an IsBoolFlag method
+	// returning true behave as if it were "true".
+	NoOptDefVal string
+}
+
+// GNUFlagSet is a Flagger and PFlagger which parses args in the GNU/POSIX
+// style without depending on anything outside the standard library. It
+// supports "--name value", "--name=value", "-n value", "-nvalue", stacked
+// short flags like "-abc", args which aren't flags mixed in with flags, and
+// "--" to mark the end of flags. Use it with WithFlagSet:
+//
+//	commandeer.New(commandeer.WithFlagSet(commandeer.NewGNUFlagSet(os.Args[0], flag.ExitOnError))).Run(main)
+type GNUFlagSet struct {
+	// Usage is called when help is requested or there's an error parsing.
+	// If nil, a default listing of the flags is printed.
+	Usage func()
+
+	name          string
+	errorHandling flag.ErrorHandling
+	output        io.Writer
+	formal        map[string]*GNUFlag
+	shorthands    map[string]*GNUFlag
+	order         []*GNUFlag
+	args          []string
+}
+
+// NewGNUFlagSet returns an empty flag set with the given name and error
+// handling behavior, which work the same as they do for flag.NewFlagSet.
+func NewGNUFlagSet(name string, errorHandling flag.ErrorHandling) *GNUFlagSet {
+	return &GNUFlagSet{
+		name:          name,
+		errorHandling: errorHandling,
+		formal:        make(map[string]*GNUFlag),
+		shorthands:    make(map[string]*GNUFlag),
+	}
+}
+
+// Name returns the name of the flag set.
+func (f *GNUFlagSet) Name() string { return f.name }
+
+// Output returns where usage and error messages go (os.Stderr by default).
+func (f *GNUFlagSet) Output() io.Writer {
+	if f.output == nil {
+		return os.Stderr
+	}
+	return f.output
+}
+
+// SetOutput sets where usage and error messages go.
+func (f *GNUFlagSet) SetOutput(w io.Writer) { f.output = w }
+
+// Args returns the args which weren't flags after parsing.
+func (f *GNUFlagSet) Args() []string { return f.args }
+
+// NArg is the number of args remaining after parsing.
+func (f *GNUFlagSet) NArg() int { return len(f.args) }
+
+// Arg returns the i'th arg remaining after parsing, or "" if there isn't one.
+func (f *GNUFlagSet) Arg(i int) string {
+	if i < 0 || i >= len(f.args) {
+		return ""
+	}
+	return f.args[i]
+}
+
+// Flags lists the names of the defined flags in the order they were defined.
+func (f *GNUFlagSet) Flags() []string {
+	names := make([]string, len(f.order))
+	for i, fl := range f.order {
+		names[i] = fl.Name
+	}
+	return names
+}
+
+// VisitAll calls fn for each flag in the order they were defined.
+func (f *GNUFlagSet) VisitAll(fn func(*GNUFlag)) {
+	for _, fl := range f.order {
+		fn(fl)
+	}
+}
+
+// Lookup returns the flag with the given name, or nil if there isn't one.
+func (f *GNUFlagSet) Lookup(name string) *GNUFlag { return f.formal[name] }
+
+// ShorthandLookup returns the flag with the given shorthand, or nil if there
+// isn't one.
+func (f *GNUFlagSet) ShorthandLookup(shorthand string) *GNUFlag { return f.shorthands[shorthand] }
+
+// Set sets the value of the named flag.
+func (f *GNUFlagSet) Set(name, value string) error {
+	fl, ok := f.formal[name]
+	if !ok {
+		return fmt.Errorf("no such flag -%v", name)
+	}
+	return fl.Value.Set(value)
+}
+
+// Var defines a flag with the given name and usage. The type and value of
+// the flag are represented by value, and its default is value's current
+// value.
+func (f *GNUFlagSet) Var(value Value, name, usage string) {
+	f.VarP(value, name, "", usage)
+}
+
+// VarP is like Var, but accepts a one letter shorthand. It panics if name or
+// shorthand is already defined, as flag.FlagSet.Var does.
+func (f *GNUFlagSet) VarP(value Value, name, shorthand, usage string) {
+	if _, ok := f.formal[name]; ok {
+		panic(fmt.Sprintf("%s flag redefined: %s", f.name, name))
+	}
+	if len(shorthand) > 1 {
+		panic(fmt.Sprintf("%q shorthand is more than one character", shorthand))
+	}
+	if _, ok := f.shorthands[shorthand]; ok && shorthand != "" {
+		panic(fmt.Sprintf("unable to redefine %q shorthand in %s flag set", shorthand, f.name))
+	}
+	fl := &GNUFlag{Name: name, Shorthand: shorthand, Usage: usage, Value: value, DefValue: value.String()}
+	f.formal[name] = fl
+	if shorthand != "" {
+		f.shorthands[shorthand] = fl
+	}
+	f.order = append(f.order, fl)
+}
+
+// Parse parses flags from args, which should not include the command name.
+// Repeated flags whose Value has an Append method (like slices) are appended
+// to rather than replaced.
+func (f *GNUFlagSet) Parse(args []string) error {
+	err := f.parse(args)
+	if err == nil {
+		return nil
+	}
+	if err != flag.ErrHelp {
+		fmt.Fprintln(f.Output(), err)
+	}
+	f.usage()
+	switch f.errorHandling {
+	case flag.ExitOnError:
+		if err == flag.ErrHelp {
+			os.Exit(0)
+		}
+		os.Exit(2)
+	case flag.PanicOnError:
+		panic(err)
+	}
+	return err
+}
+
+func (f *GNUFlagSet) parse(args []string) error {
+	f.args = make([]string, 0, len(args))
+	seen := make(map[*GNUFlag]bool)
+	for len(args) > 0 {
+		arg := args[0]
+		args = args[1:]
+		switch {
+		case arg == "--":
+			f.args = append(f.args, args...)
+			return nil
+		case strings.HasPrefix(arg, "--"):
+			var err error
+			args, err = f.parseLong(arg[2:], args, seen)
+			if err != nil {
+				return err
+			}
+		case len(arg) > 1 && arg[0] == '-':
+			var err error
+			args, err = f.parseShorts(arg[1:], args, seen)
+			if err != nil {
+				return err
+			}
+		default:
+			f.args = append(f.args, arg)
+		}
+	}
+	return nil
+}
+
+// parseLong parses "name" or "name=value" from a "--" arg, taking the value
+// from the remaining args if necessary.
+func (f *GNUFlagSet) parseLong(arg string, args []string, seen map[*GNUFlag]bool) ([]string, error) {
+	name, value, hasValue := strings.Cut(arg, "=")
+	if name == "" || name[0] == '-' || name[0] == '=' {
+		return args, fmt.Errorf("bad flag syntax: --%s", arg)
+	}
+	fl, ok := f.formal[name]
+	if !ok {
+		if name == "help" {
+			return args, flag.ErrHelp
+		}
+		return args, fmt.Errorf("unknown flag: --%s", name)
+	}
+	switch {
+	case hasValue:
+	case fl.noOptValue() != "":
+		value = fl.noOptValue()
+	case len(args) > 0:
+		value, args = args[0], args[1:]
+	default:
+		return args, fmt.Errorf("flag needs an argument: --%s", name)
+	}
+	return args, f.setParsed(fl, "--"+name, value, seen)
+}
+
+// parseShorts parses one or more stacked shorthands from a "-" arg. The first
+// which needs a value takes the rest of the arg (or the next arg if there is
+// nothing left) as its value.
+func (f *GNUFlagSet) parseShorts(shorts string, args []string, seen map[*GNUFlag]bool) ([]string, error) {
+	cluster := shorts
+	for len(shorts) > 0 {
+		short := shorts[:1]
+		shorts = shorts[1:]
+		fl, ok := f.shorthands[short]
+		if !ok {
+			if short == "h" {
+				return args, flag.ErrHelp
+			}
+			return args, fmt.Errorf("unknown shorthand flag: '%s' in -%s", short, cluster)
+		}
+		var value string
+		switch {
+		case strings.HasPrefix(shorts, "="):
+			value, shorts = shorts[1:], ""
+		case fl.noOptValue() != "":
+			value = fl.noOptValue()
+		case len(shorts) > 0:
+			value, shorts = shorts, ""
+		case len(args) > 0:
+			value, args = args[0], args[1:]
+		default:
+			return args, fmt.Errorf("flag needs an argument: '%s' in -%s", short, cluster)
+		}
+		if err := f.setParsed(fl, "-"+short, value, seen); err != nil {
+			return args, err
+		}
+	}
+	return args, nil
+}
+
+// setParsed sets fl to value, appending to it if it has already been seen in
+// this parse and supports appending.
+func (f *GNUFlagSet) setParsed(fl *GNUFlag, arg, value string, seen map[*GNUFlag]bool) error {
+	var err error
+	if appender, ok := fl.Value.(interface{ Append(string) error }); ok && seen[fl] {
+		err = appender.Append(value)
+	} else {
+		err = fl.Value.Set(value)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid argument %q for %q flag: %v", value, arg, err)
+	}
+	seen[fl] = true
+	return nil
+}
+
+// noOptValue gets the value the flag should be set to if none is given.
+func (fl *GNUFlag) noOptValue() string {
+	if fl.NoOptDefVal != "" {
+		return fl.NoOptDefVal
+	}
+	if bf, ok := fl.Value.(interface{ IsBoolFlag() bool }); ok && bf.IsBoolFlag() {
+		return "true"
+	}
+	return ""
+}
+
+func (f *GNUFlagSet) usage() {
+	if f.Usage != nil {
+		f.Usage()
+		return
+	}
+	if f.name == "" {
+		fmt.Fprintf(f.Output(), "Usage:\n")
+	} else {
+		fmt.Fprintf(f.Output(), "Usage of %s:\n", f.name)
+	}
+	f.PrintDefaults()
+}
+
+// PrintDefaults prints the flags and their usage and default values.
+func (f *GNUFlagSet) PrintDefaults() {
+	for _, fl := range f.order {
+		line := "      --" + fl.Name
+		if fl.Shorthand != "" {
+			line = "  -" + fl.Shorthand + ", --" + fl.Name
+		}
+		if fl.noOptValue() == "" {
+			line += " " + fl.Value.Type()
+		}
+		line += "\n    \t" + strings.ReplaceAll(fl.Usage, "\n", "\n    \t")
+		if !isZeroDefault(fl.DefValue) {
+			line += fmt.Sprintf(" (default %s)", fl.DefValue)
+		}
+		fmt.Fprintln(f.Output(), line)
+	}
+}
+
+// StringVar defines a string flag.
+func (f *GNUFlagSet) StringVar(p *string, name string, value string, usage string) {
+	f.StringVarP(p, name, "", value, usage)
+}
+
+// StringVarP defines a string flag with a shorthand.
+func (f *GNUFlagSet) StringVarP(p *string, name string, shorthand string, value string, usage string) {
+	*p = value
+	f.VarP(stringValue{p}, name, shorthand, usage)
+}
+
+// IntVar defines an int flag.
+func (f *GNUFlagSet) IntVar(p *int, name string, value int, usage string) {
+	f.IntVarP(p, name, "", value, usage)
+}
+
+// IntVarP defines an int flag with a shorthand.
+func (f *GNUFlagSet) IntVarP(p *int, name string, shorthand string, value int, usage string) {
+	*p = value
+	f.VarP(intValue[int]{p}, name, shorthand, usage)
+}
+
+// Int8VarP defines an int8 flag with a shorthand.
+func (f *GNUFlagSet) Int8VarP(p *int8, name string, shorthand string, value int8, usage string) {
+	*p = value
+	f.VarP(intValue[int8]{p}, name, shorthand, usage)
+}
+
+// Int16VarP defines an int16 flag with a shorthand.
+func (f *GNUFlagSet) Int16VarP(p *int16, name string, shorthand string, value int16, usage string) {
+	*p = value
+	f.VarP(intValue[int16]{p}, name, shorthand, usage)
+}
+
+// Int32VarP defines an int32 flag with a shorthand.
+func (f *GNUFlagSet) Int32VarP(p *int32, name string, shorthand string, value int32, usage string) {
+	*p = value
+	f.VarP(intValue[int32]{p}, name, shorthand, usage)
+}
+
+// Int64Var defines an int64 flag.
+func (f *GNUFlagSet) Int64Var(p *int64, name string, value int64, usage string) {
+	f.Int64VarP(p, name, "", value, usage)
+}
+
+// Int64VarP defines an int64 flag with a shorthand.
+func (f *GNUFlagSet) Int64VarP(p *int64, name string, shorthand string, value int64, usage string) {
+	*p = value
+	f.VarP(intValue[int64]{p}, name, shorthand, usage)
+}
+
+// UintVar defines a uint flag.
+func (f *GNUFlagSet) UintVar(p *uint, name string, value uint, usage string) {
+	f.UintVarP(p, name, "", value, usage)
+}
+
+// UintVarP defines a uint flag with a shorthand.
+func (f *GNUFlagSet) UintVarP(p *uint, name string, shorthand string, value uint, usage string) {
+	*p = value
+	f.VarP(uintValue[uint]{p}, name, shorthand, usage)
+}
+
+// Uint8VarP defines a uint8 flag with a shorthand.
+func (f *GNUFlagSet) Uint8VarP(p *uint8, name string, shorthand string, value uint8, usage string) {
+	*p = value
+	f.VarP(uintValue[uint8]{p}, name, shorthand, usage)
+}
+
+// Uint16VarP defines a uint16 flag with a shorthand.
+func (f *GNUFlagSet) Uint16VarP(p *uint16, name string, shorthand string, value uint16, usage string) {
+	*p = value
+	f.VarP(uintValue[uint16]{p}, name, shorthand, usage)
+}
+
+// Uint32VarP defines a uint32 flag with a shorthand.
+func (f *GNUFlagSet) Uint32VarP(p *uint32, name string, shorthand string, value uint32, usage string) {
+	*p = value
+	f.VarP(uintValue[uint32]{p}, name, shorthand, usage)
+}
+
+// Uint64Var defines a uint64 flag.
+func (f *GNUFlagSet) Uint64Var(p *uint64, name string, value uint64, usage string) {
+	f.Uint64VarP(p, name, "", value, usage)
+}
+
+// Uint64VarP defines a uint64 flag with a shorthand.
+func (f *GNUFlagSet) Uint64VarP(p *uint64, name string, shorthand string, value uint64, usage string) {
+	*p = value
+	f.VarP(uintValue[uint64]{p}, name, shorthand, usage)
+}
+
+// Float32VarP defines a float32 flag with a shorthand.
+func (f *GNUFlagSet) Float32VarP(p *float32, name string, shorthand string, value float32, usage string) {
+	*p = value
+	f.VarP(floatValue[float32]{p}, name, shorthand, usage)
+}
+
+// Float64Var defines a float64 flag.
+func (f *GNUFlagSet) Float64Var(p *float64, name string, value float64, usage string) {
+	f.Float64VarP(p, name, "", value, usage)
+}
+
+// Float64VarP defines a float64 flag with a shorthand.
+func (f *GNUFlagSet) Float64VarP(p *float64, name string, shorthand string, value float64, usage string) {
+	*p = value
+	f.VarP(floatValue[float64]{p}, name, shorthand, usage)
+}
+
+// BoolVar defines a bool flag.
+func (f *GNUFlagSet) BoolVar(p *bool, name string, value bool, usage string) {
+	f.BoolVarP(p, name, "", value, usage)
+}
+
+// BoolVarP defines a bool flag with a shorthand.
+func (f *GNUFlagSet) BoolVarP(p *bool, name string, shorthand string, value bool, usage string) {
+	*p = value
+	f.VarP(boolValue{p}, name, shorthand, usage)
+}
+
+// DurationVar defines a time.Duration flag.
+func (f *GNUFlagSet) DurationVar(p *time.Duration, name string, value time.Duration, usage string) {
+	f.DurationVarP(p, name, "", value, usage)
+}
+
+// DurationVarP defines a time.Duration flag with a shorthand.
+func (f *GNUFlagSet) DurationVarP(p *time.Duration, name string, shorthand string, value time.Duration, usage string) {
+	*p = value
+	f.VarP(durationValue{p}, name, shorthand, usage)
+}
+
+// StringSliceVarP defines a []string flag with a shorthand.
+func (f *GNUFlagSet) StringSliceVarP(p *[]string, name string, shorthand string, value []string, usage string) {
+	*p = value
+	f.VarP(sliceValue[string]{p, func(s string) (string, error) { return s, nil }, func(s string) string { return s }, "stringSlice"}, name, shorthand, usage)
+}
+
+// BoolSliceVarP defines a []bool flag with a shorthand.
+func (f *GNUFlagSet) BoolSliceVarP(p *[]bool, name string, shorthand string, value []bool, usage string) {
+	*p = value
+	f.VarP(sliceValue[bool]{p, parseBool, func(b bool) string { return fmt.Sprint(b) }, "boolSlice"}, name, shorthand, usage)
+}
+
+// IntSliceVarP defines a []int flag with a shorthand.
+func (f *GNUFlagSet) IntSliceVarP(p *[]int, name string, shorthand string, value []int, usage string) {
+	*p = value
+	f.VarP(sliceValue[int]{p, parseInt, func(n int) string { return fmt.Sprint(n) }, "intSlice"}, name, shorthand, usage)
+}
+
+// UintSliceVarP defines a []uint flag with a shorthand.
+func (f *GNUFlagSet) UintSliceVarP(p *[]uint, name string, shorthand string, value []uint, usage string) {
+	*p = value
+	f.VarP(sliceValue[uint]{p, parseUint, func(n uint) string { return fmt.Sprint(n) }, "uintSlice"}, name, shorthand, usage)
+}
+
+// IPSliceVarP defines a []net.IP flag with a shorthand.
+func (f *GNUFlagSet) IPSliceVarP(p *[]net.IP, name string, shorthand string, value []net.IP, usage string) {
+	*p = value
+	f.VarP(sliceValue[net.IP]{p, parseIP, net.IP.String, "ipSlice"}, name, shorthand, usage)
+}
+
+// IPVarP defines a net.IP flag with a shorthand.
+func (f *GNUFlagSet) IPVarP(p *net.IP, name string, shorthand string, value net.IP, usage string) {
+	*p = value
+	f.VarP(ipValue{p}, name, shorthand, usage)
+}
+
+// IPMaskVarP defines a net.IPMask flag with a shorthand.
+func (f *GNUFlagSet) IPMaskVarP(p *net.IPMask, name string, shorthand string, value net.IPMask, usage string) {
+	*p = value
+	f.VarP(ipMaskValue{p}, name, shorthand, usage)
+}
+
+// IPNetVarP defines a net.IPNet flag with a shorthand.
+func (f *GNUFlagSet) IPNetVarP(p *net.IPNet, name string, shorthand string, value net.IPNet, usage string) {
+	*p = value
+	f.VarP(ipNetValue{p}, name, shorthand, usage)
+}
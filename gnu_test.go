@@ -0,0 +1,126 @@
+package commandeer
+
+import (
+	"bytes"
+	"flag"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type gnuMain struct {
+	Verbose bool     `short:"v"`
+	All     bool     `short:"a"`
+	Output  string   `short:"o"`
+	Num     int8     `short:"n"`
+	Tags    []string `short:"t"`
+	Ratio   float32
+	Wait    time.Duration
+	Addr    net.IP
+	Nums    []int
+}
+
+func TestGNUFlagSet(t *testing.T) {
+	tests := []struct {
+		args []string
+		exp  gnuMain
+		rest []string
+	}{
+		{
+			args: []string{"--verbose", "--output", "out", "--num=3"},
+			exp:  gnuMain{Verbose: true, Output: "out", Num: 3},
+			rest: []string{},
+		},
+		{
+			args: []string{"-va", "-oout", "pos", "-n", "-2", "other"},
+			exp:  gnuMain{Verbose: true, All: true, Output: "out", Num: -2},
+			rest: []string{"pos", "other"},
+		},
+		{
+			args: []string{"-avo", "out", "--", "-n", "3"},
+			exp:  gnuMain{Verbose: true, All: true, Output: "out"},
+			rest: []string{"-n", "3"},
+		},
+		{
+			args: []string{"--verbose=false", "-o=out", "-", "--wait", "2s", "--ratio", "0.5"},
+			exp:  gnuMain{Output: "out", Wait: 2 * time.Second, Ratio: 0.5},
+			rest: []string{"-"},
+		},
+		{
+			args: []string{"-t", "a,b", "--tags", "c", "--nums=1,2", "--nums", "3", "--addr", "10.0.0.1"},
+			exp:  gnuMain{Tags: []string{"a", "b", "c"}, Nums: []int{1, 2, 3}, Addr: net.ParseIP("10.0.0.1")},
+			rest: []string{},
+		},
+	}
+	for _, tst := range tests {
+		t.Run(strings.Join(tst.args, " "), func(t *testing.T) {
+			fs := NewGNUFlagSet("gnu", flag.ContinueOnError)
+			mm := &gnuMain{}
+			err := LoadArgsEnv(fs, mm, tst.args, "", nil)
+			if err != nil {
+				t.Fatalf("loading: %v", err)
+			}
+			if !reflect.DeepEqual(*mm, tst.exp) {
+				t.Errorf("unexpected values:\n%+v\nexpected:\n%+v", *mm, tst.exp)
+			}
+			if !reflect.DeepEqual(fs.Args(), tst.rest) {
+				t.Errorf("unexpected args: %q", fs.Args())
+			}
+		})
+	}
+}
+
+func TestGNUFlagSetErrors(t *testing.T) {
+	tests := []struct {
+		args []string
+		err  string
+	}{
+		{args: []string{"--nope"}, err: "unknown flag: --nope"},
+		{args: []string{"-vx"}, err: "unknown shorthand flag: 'x' in -vx"},
+		{args: []string{"--output"}, err: "flag needs an argument: --output"},
+		{args: []string{"-o"}, err: "flag needs an argument: 'o' in -o"},
+		{args: []string{"-vo"}, err: "flag needs an argument: 'o' in -vo"},
+		{args: []string{"-n", "1000"}, err: `invalid argument "1000" for "-n" flag: value out of range`},
+		{args: []string{"---verbose"}, err: "bad flag syntax: ---verbose"},
+		{args: []string{"--help"}, err: flag.ErrHelp.Error()},
+		{args: []string{"-vh"}, err: flag.ErrHelp.Error()},
+	}
+	for _, tst := range tests {
+		t.Run(strings.Join(tst.args, " "), func(t *testing.T) {
+			fs := NewGNUFlagSet("gnu", flag.ContinueOnError)
+			buf := &bytes.Buffer{}
+			fs.SetOutput(buf)
+			err := LoadArgsEnv(fs, &gnuMain{}, tst.args, "", nil)
+			if err == nil || !strings.HasSuffix(err.Error(), tst.err) {
+				t.Fatalf("expected error '%s', got: %v", tst.err, err)
+			}
+			if !strings.Contains(buf.String(), "Usage of gnu:") {
+				t.Errorf("usage wasn't printed:\n%s", buf)
+			}
+		})
+	}
+}
+
+func TestGNUFlagSetUsage(t *testing.T) {
+	fs := NewGNUFlagSet("gnu", flag.ContinueOnError)
+	buf := &bytes.Buffer{}
+	fs.SetOutput(buf)
+	err := Flags(fs, &gnuMain{Output: "out"})
+	if err != nil {
+		t.Fatalf("setting up flags: %v", err)
+	}
+	fs.Usage()
+	for _, line := range []string{
+		"  -v, --[no-]verbose\n",
+		"  -o, --output string\n",
+		`(default "out")`,
+		"  -t, --tags strings\n",
+		"      --addr ip\n",
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("usage doesn't contain %q:\n%s", line, buf)
+		}
+	}
+}
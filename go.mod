@@ -1,6 +1,6 @@
 module github.com/jaffee/commandeer
 
-go 1.12
+go 1.18
 
 require (
 	github.com/inconshreveable/mousetrap v1.0.0 // indirect
@@ -0,0 +1,355 @@
+package commandeer
+
+import (
+	"flag"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	implsMu sync.RWMutex
+	impls   = make(map[reflect.Type]map[string]reflect.Value)
+)
+
+// RegisterImpl makes impl an implementation of the interface I which can be
+// selected by name for fields of type I. Such a field gets a flag which takes
+// the name of an implementation, and each implementation's fields get flags
+// prefixed with the field's flag name, e.g.
+//
+//	commandeer.RegisterImpl[Storage]("local", &LocalStorage{Dir: "/var/lib/app"})
+//	commandeer.RegisterImpl[Storage]("s3", &S3Storage{Region: "us-east-1"})
+//
+// lets a Storage field be set with "--storage=local --storage.dir=/data". Only
+// the flags of the selected implementation may be given (and only its paths
+// are checked and its files opened), and the field is set to a copy of impl
+// with them applied once everything is loaded. If the field
+// already holds one of the registered types, that implementation is the
+// default. In config files, the implementation can also be given as the
+// "type" key of an object, e.g. {"storage": {"type": "s3", "region": "..."}}.
+//
+// impl is usually a pointer to a struct, and is copied (shallowly) for each
+// use. RegisterImpl panics if I isn't an interface type.
+func RegisterImpl[I any](name string, impl I) {
+	typ := reflect.TypeOf((*I)(nil)).Elem()
+	if typ.Kind() != reflect.Interface {
+		panic(fmt.Sprintf("commandeer.RegisterImpl: %v is not an interface", typ))
+	}
+	v := reflect.ValueOf(&impl).Elem().Elem()
+	if !v.IsValid() || (v.Kind() == reflect.Ptr && (v.IsNil() || v.Elem().Kind() != reflect.Struct)) || (v.Kind() != reflect.Ptr && v.Kind() != reflect.Struct) {
+		panic(fmt.Sprintf("commandeer.RegisterImpl: %s must be a struct or a pointer to one", name))
+	}
+	implsMu.Lock()
+	defer implsMu.Unlock()
+	if impls[typ] == nil {
+		impls[typ] = make(map[string]reflect.Value)
+	}
+	impls[typ][name] = v
+}
+
+// lookupImpls gets the implementations registered for an interface type.
+func lookupImpls(typ reflect.Type) (map[string]reflect.Value, bool) {
+	implsMu.RLock()
+	defer implsMu.RUnlock()
+	registered, ok := impls[typ]
+	if !ok {
+		return nil, false
+	}
+	copied := make(map[string]reflect.Value, len(registered))
+	for name, impl := range registered {
+		copied[name] = impl
+	}
+	return copied, true
+}
+
+// implValue is the Value for an interface field with registered
+// implementations, and is set to the name of one. Each implementation has its
+// own copy of the registered value which its flags are bound to; apply
+// sets the field to the selected one.
+type implValue struct {
+	name     string
+	field    reflect.Value
+	names    []string
+	selected string
+	// impls holds each implementation's copy (as a pointer if it was
+	// registered as one), subs holds the trackers their flags were set up
+	// with, and flags are those of all implementations.
+	impls map[string]reflect.Value
+	subs  map[string]*flagTracker
+	flags []*implFlagValue
+}
+
+func (v *implValue) Set(s string) error {
+	if _, ok := v.impls[s]; !ok {
+		return fmt.Errorf("unknown implementation '%s', expected one of: %s", s, strings.Join(v.names, ", "))
+	}
+	v.selected = s
+	return nil
+}
+
+func (v *implValue) String() string {
+	if v == nil {
+		return ""
+	}
+	return v.selected
+}
+
+func (v *implValue) Type() string {
+	if v == nil {
+		return ""
+	}
+	return strings.Join(v.names, "|")
+}
+
+// SetKey lets config sources select the implementation with a "type" key in
+// the field's object. Its other keys are the implementation's flags.
+func (v *implValue) SetKey(key, value string) error {
+	if key != "type" {
+		return fmt.Errorf("unknown option '%s'", key)
+	}
+	return v.Set(value)
+}
+
+// check returns an error if a flag which the selected implementation doesn't
+// have was given, or the first error from setting one of its flags.
+func (v *implValue) check() error {
+	for _, f := range v.flags {
+		if _, ok := f.values[v.selected]; ok || !f.given {
+			continue
+		}
+		var names []string
+		for _, name := range v.names {
+			if _, ok := f.values[name]; ok {
+				names = append(names, name)
+			}
+		}
+		if v.selected == "" {
+			return fmt.Errorf("%s is only for %s, but %s isn't set", f.name, strings.Join(names, ", "), v.name)
+		}
+		return fmt.Errorf("%s is only for %s, but %s=%s", f.name, strings.Join(names, ", "), v.name, v.selected)
+	}
+	for _, f := range v.flags {
+		if err := f.errs[v.selected]; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply sets the field to the selected implementation.
+func (v *implValue) apply() {
+	if v.selected != "" {
+		v.field.Set(v.impls[v.selected])
+	}
+}
+
+// implFlagValue is the Value for a flag of one or more implementations. Since
+// the implementation may not be selected until after its flags are set, values
+// are set on every implementation which has the flag, and errors are recorded
+// so that only those of the selected implementation are returned once loading
+// is done. given records whether the flag was set at all, since that's an
+// error if the selected implementation doesn't have it.
+type implFlagValue struct {
+	name   string
+	values map[string]Value
+	first  string
+	errs   map[string]error
+	given  bool
+}
+
+func (v *implFlagValue) Set(s string) error {
+	return v.set(s, false)
+}
+
+func (v *implFlagValue) Append(s string) error {
+	return v.set(s, true)
+}
+
+func (v *implFlagValue) set(s string, append bool) error {
+	v.given = true
+	for impl, value := range v.values {
+		var err error
+		if appender, ok := value.(interface{ Append(string) error }); ok && append {
+			err = appender.Append(s)
+		} else {
+			err = value.Set(s)
+		}
+		if err != nil && v.errs[impl] == nil {
+			if v.errs == nil {
+				v.errs = make(map[string]error)
+			}
+			v.errs[impl] = fmt.Errorf("couldn't set %s to %s: %v", v.name, s, err)
+		}
+	}
+	return nil
+}
+
+func (v *implFlagValue) String() string {
+	if v == nil || v.values == nil {
+		return ""
+	}
+	return v.values[v.first].String()
+}
+
+func (v *implFlagValue) Type() string {
+	if v == nil || v.values == nil {
+		return ""
+	}
+	return v.values[v.first].Type()
+}
+
+func (v *implFlagValue) IsBoolFlag() bool {
+	if v == nil || v.values == nil {
+		return false
+	}
+	bf, ok := v.values[v.first].(interface{ IsBoolFlag() bool })
+	return ok && bf.IsBoolFlag()
+}
+
+// bareValue lets count flags of implementations be given without a value on
+// flag sets without NoOptDefVal.
+func (v *implFlagValue) bareValue() string {
+	if v == nil || v.values == nil {
+		return ""
+	}
+	bv, ok := v.values[v.first].(interface{ bareValue() string })
+	if !ok {
+		return ""
+	}
+	return bv.bareValue()
+}
+
+// implFlags sets up the flag which selects an implementation for an interface
+// field, and the flags of every implementation. Each implementation's flags
+// are set up on a GNUFlagSet of their own first, and then added to this flag
+// set in a usage section for the implementation, with flags which more than
+// one implementation has being shared between them.
+func (fTr *flagTracker) implFlags(ft reflect.StructField, f reflect.Value, flagName, shorthand, help string, registered map[string]reflect.Value) error {
+	v := &implValue{name: flagName, field: f, impls: make(map[string]reflect.Value), subs: make(map[string]*flagTracker)}
+	for name := range registered {
+		v.names = append(v.names, name)
+	}
+	sort.Strings(v.names)
+
+	// flags are only added to the flag set once every implementation's
+	// have been found, so that their defaults come from the default
+	// implementation if it has them.
+	shared := make(map[string]*implFlagValue)
+	var added []*GNUFlag
+	for _, name := range v.names {
+		impl := registered[name]
+		if !f.IsNil() && f.Elem().Type() == impl.Type() {
+			impl = f.Elem()
+			v.selected = name
+		}
+		var ptr reflect.Value
+		if impl.Kind() == reflect.Ptr {
+			ptr = reflect.New(impl.Type().Elem())
+			ptr.Elem().Set(impl.Elem())
+			v.impls[name] = ptr
+		} else {
+			ptr = reflect.New(impl.Type())
+			ptr.Elem().Set(impl)
+			v.impls[name] = ptr.Elem()
+		}
+
+		gnu := NewGNUFlagSet(name, flag.ContinueOnError)
+		sub := fTr.subTracker(gnu)
+		sub.path = fTr.path + "." + ft.Name + "(" + name + ")"
+		sub.envPath = fTr.envKeys[flagName]
+		sub.group = sub.section(ft.Name + " (" + flagName + "=" + name + ")")
+		err := setFlags(sub, ptr.Interface(), flagName)
+		if err != nil {
+			return err
+		}
+		for _, counter := range sub.counters {
+			counter.bareTrue = !fTr.pflag
+		}
+		v.subs[name] = sub
+		for _, g := range sub.groups {
+			group := fTr.section(g.name)
+			if g.desc != "" {
+				group.desc = g.desc
+			}
+			for _, info := range g.flags {
+				gf := gnu.Lookup(info.name)
+				if gf == nil {
+					continue
+				}
+				if other, ok := shared[info.name]; ok {
+					other.values[name] = gf.Value
+					if name == v.selected {
+						other.first = name
+					}
+					fTr.infos[info.name].help += " (also for " + name + ")"
+					continue
+				}
+				err = fTr.claim(info.name, sub.fields[info.name])
+				if err != nil {
+					return err
+				}
+				ifv := &implFlagValue{name: info.name, values: map[string]Value{name: gf.Value}, first: name}
+				shared[info.name] = ifv
+				v.flags = append(v.flags, ifv)
+				fTr.envKeys[info.name] = sub.envKeys[info.name]
+				added = append(added, gf)
+				info.short = ""
+				info.group = group
+				group.flags = append(group.flags, info)
+				fTr.infos[info.name] = info
+			}
+		}
+	}
+	for i, gf := range added {
+		fTr.value(v.flags[i], gf.Name, "", gf.Usage)
+		if gf.NoOptDefVal != "" {
+			fTr.setNoOptDefVal(gf.Name, gf.NoOptDefVal)
+		}
+	}
+	fTr.vvarp(v, flagName, shorthand, help)
+	fTr.impls = append(fTr.impls, v)
+	return nil
+}
+
+// applyImpls sets interface fields to their selected implementations once
+// everything has been loaded, returning the first error from setting the
+// flags of one instead if there was one.
+func (fTr *flagTracker) applyImpls() error {
+	var err error
+	for _, t := range fTr.trackers(false) {
+		for _, impl := range t.impls {
+			if err == nil {
+				err = impl.check()
+			}
+		}
+	}
+	for _, t := range fTr.trackers(true) {
+		for _, impl := range t.impls {
+			for _, f := range impl.flags {
+				f.errs, f.given = nil, false
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	fTr.assign()
+	return nil
+}
+
+// assign sets interface fields to their selected implementations and copies
+// the elements of slices of structs into them, innermost first so that
+// implementations and elements which are copied by value include theirs.
+func (fTr *flagTracker) assign() {
+	ts := fTr.trackers(false)
+	for i := len(ts) - 1; i >= 0; i-- {
+		for _, impl := range ts[i].impls {
+			impl.apply()
+		}
+		for _, v := range ts[i].structSlices {
+			v.store()
+		}
+	}
+}
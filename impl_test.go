@@ -0,0 +1,204 @@
+package commandeer
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type storage interface {
+	Kind() string
+}
+
+type localStorage struct {
+	Dir  string `help:"data directory"`
+	Sync bool
+}
+
+func (s *localStorage) Kind() string { return "local" }
+
+type s3Storage struct {
+	Bucket string
+	Region string
+	Dir    string `help:"key prefix"`
+}
+
+func (s *s3Storage) Kind() string { return "s3" }
+
+type memoryStorage struct {
+	Size int
+}
+
+func (s memoryStorage) Kind() string { return "memory" }
+
+func init() {
+	RegisterImpl[storage]("local", &localStorage{Dir: "/var/lib/app"})
+	RegisterImpl[storage]("s3", &s3Storage{Region: "us-east-1"})
+	RegisterImpl[storage]("memory", memoryStorage{Size: 64})
+}
+
+type implMain struct {
+	Name    string
+	Storage storage `help:"where to keep data"`
+}
+
+func TestRegisterImpl(t *testing.T) {
+	for name, newFlagger := range testFlaggers() {
+		t.Run(name, func(t *testing.T) {
+			mm := &implMain{}
+			args := []string{"--storage.dir", "/data", "--storage=local", "--storage.sync"}
+			err := LoadArgsEnv(newFlagger(), mm, args, "", nil)
+			if err != nil {
+				t.Fatalf("loading: %v", err)
+			}
+			local, ok := mm.Storage.(*localStorage)
+			if !ok || local.Dir != "/data" || !local.Sync {
+				t.Errorf("unexpected storage: %#v", mm.Storage)
+			}
+		})
+	}
+
+	mm := &implMain{}
+	err := LoadArgsEnv(flag.NewFlagSet("", flag.ContinueOnError), mm, []string{"-storage=s3", "-storage.bucket=b", "-storage.dir=logs/"}, "", nil)
+	if err != nil {
+		t.Fatalf("loading: %v", err)
+	}
+	if s3, ok := mm.Storage.(*s3Storage); !ok || *s3 != (s3Storage{Bucket: "b", Region: "us-east-1", Dir: "logs/"}) {
+		t.Errorf("unexpected storage: %#v", mm.Storage)
+	}
+
+	mm = &implMain{}
+	err = LoadArgsEnv(flag.NewFlagSet("", flag.ContinueOnError), mm, nil, "", nil)
+	if err != nil || mm.Storage != nil {
+		t.Errorf("expected storage to be left alone, got %#v, %v", mm.Storage, err)
+	}
+
+	mm = &implMain{Storage: memoryStorage{Size: 8}}
+	mustSetenv(t, "IMPL_STORAGE_SIZE", "16")
+	defer os.Unsetenv("IMPL_STORAGE_SIZE")
+	err = LoadArgsEnv(flag.NewFlagSet("", flag.ContinueOnError), mm, nil, "IMPL_", nil)
+	if err != nil {
+		t.Fatalf("loading: %v", err)
+	}
+	if mem, ok := mm.Storage.(memoryStorage); !ok || mem.Size != 16 {
+		t.Errorf("expected the default implementation to be set from the environment, got %#v", mm.Storage)
+	}
+}
+
+func TestRegisterImplSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(path, []byte(`{"storage": {"type": "s3", "bucket": "from-file"}}`), 0600)
+	if err != nil {
+		t.Fatalf("writing config: %v", err)
+	}
+	mm := &implMain{}
+	err = New(WithFlagSet(flag.NewFlagSet("", flag.ContinueOnError)), WithArgs([]string{"-storage.region", "eu-west-1"}), WithSources(JSONFile(path))).Load(mm)
+	if err != nil {
+		t.Fatalf("loading: %v", err)
+	}
+	if s3, ok := mm.Storage.(*s3Storage); !ok || s3.Bucket != "from-file" || s3.Region != "eu-west-1" {
+		t.Errorf("unexpected storage: %#v", mm.Storage)
+	}
+}
+
+type cache interface {
+	Kind() string
+}
+
+type diskCache struct {
+	Dir     string `path:"dir,exists"`
+	Verbose int    `count:"true"`
+	Log     io.Writer
+}
+
+func (c *diskCache) Kind() string { return "disk" }
+
+type noCache struct {
+	Verbose int `count:"true"`
+}
+
+func (c *noCache) Kind() string { return "none" }
+
+func init() {
+	RegisterImpl[cache]("disk", &diskCache{Dir: "/nonexistent"})
+	RegisterImpl[cache]("none", &noCache{})
+}
+
+func TestRegisterImplHooks(t *testing.T) {
+	log := filepath.Join(t.TempDir(), "log")
+	for name, newFlagger := range testFlaggers() {
+		t.Run(name, func(t *testing.T) {
+			mm := &struct{ Cache cache }{}
+			com := New(WithFlagSet(newFlagger()), WithArgs([]string{"--cache=disk", "--cache.dir", t.TempDir(), "--cache.verbose", "--cache.verbose", "--cache.verbose", "--cache.log", log}))
+			err := com.Load(mm)
+			if err != nil {
+				t.Fatalf("loading: %v", err)
+			}
+			defer com.Close()
+			disk, ok := mm.Cache.(*diskCache)
+			if !ok || disk.Verbose != 3 || disk.Log == nil {
+				t.Errorf("unexpected cache: %#v", mm.Cache)
+			}
+
+			err = LoadArgsEnv(newFlagger(), &struct{ Cache cache }{}, []string{"--cache=disk", "--cache.dir=/nonexistent"}, "", nil)
+			if err == nil || !strings.Contains(err.Error(), "checking paths: cache.dir: /nonexistent doesn't exist") {
+				t.Errorf("unexpected error: %v", err)
+			}
+
+			err = LoadArgsEnv(newFlagger(), &struct{ Cache cache }{}, []string{"--cache=none"}, "", nil)
+			if err != nil {
+				t.Fatalf("expected the paths of other implementations to be skipped, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestRegisterImplErrors(t *testing.T) {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.SetOutput(&bytes.Buffer{})
+	err := LoadArgsEnv(fs, &implMain{}, []string{"-storage=disk"}, "", nil)
+	if err == nil || !strings.Contains(err.Error(), "unknown implementation 'disk', expected one of: local, memory, s3") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	err = LoadArgsEnv(flag.NewFlagSet("", flag.ContinueOnError), &implMain{}, []string{"-storage=memory", "-storage.size=big"}, "", nil)
+	if err == nil || !strings.Contains(err.Error(), "setting implementations: couldn't set storage.size to big") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	err = LoadArgsEnv(flag.NewFlagSet("", flag.ContinueOnError), &implMain{}, []string{"-storage=local", "-storage.bucket=b"}, "", nil)
+	if err == nil || !strings.Contains(err.Error(), "setting implementations: storage.bucket is only for s3, but storage=local") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	err = LoadArgsEnv(flag.NewFlagSet("", flag.ContinueOnError), &implMain{}, []string{"-storage.dir=/d"}, "", nil)
+	if err == nil || !strings.Contains(err.Error(), "setting implementations: storage.dir is only for local, s3, but storage isn't set") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	mustSetenv(t, "IMPL_STORAGE_BUCKET", "b")
+	defer os.Unsetenv("IMPL_STORAGE_BUCKET")
+	err = LoadArgsEnv(flag.NewFlagSet("", flag.ContinueOnError), &implMain{Storage: memoryStorage{}}, nil, "IMPL_", nil)
+	if err == nil || !strings.Contains(err.Error(), "setting implementations: storage.bucket is only for s3, but storage=memory") {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	fs = flag.NewFlagSet("", flag.ContinueOnError)
+	usage := &bytes.Buffer{}
+	fs.SetOutput(usage)
+	err = Flags(fs, &implMain{})
+	if err != nil {
+		t.Fatalf("setting up flags: %v", err)
+	}
+	fs.Usage()
+	for _, want := range []string{
+		"-storage local|memory|s3\n    \twhere to keep data",
+		"Storage (storage=local):\n  -storage.dir string\n    \tdata directory (also for s3) (default \"/var/lib/app\")",
+		"Storage (storage=memory):\n  -storage.size int",
+		"Storage (storage=s3):\n  -storage.bucket string",
+	} {
+		if !strings.Contains(usage.String(), want) {
+			t.Errorf("expected usage to contain:\n%s\ngot:\n%s", want, usage)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package commandeer
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+)
+
+// This file has Values for network address types beyond the net.IP family
+// (which is in values.go), along with HostPort.
+
+type addrValue struct {
+	value *netip.Addr
+}
+
+func (v addrValue) Set(s string) error {
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return fmt.Errorf("invalid IP address: '%s'", s)
+	}
+	*v.value = addr
+	return nil
+}
+
+func (v addrValue) String() string {
+	if v.value == nil || !v.value.IsValid() {
+		return ""
+	}
+	return v.value.String()
+}
+
+func (v addrValue) Type() string { return "ip" }
+
+type prefixValue struct {
+	value *netip.Prefix
+}
+
+func (v prefixValue) Set(s string) error {
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return fmt.Errorf("invalid CIDR prefix: '%s'", s)
+	}
+	*v.value = prefix
+	return nil
+}
+
+func (v prefixValue) String() string {
+	if v.value == nil || !v.value.IsValid() {
+		return ""
+	}
+	return v.value.String()
+}
+
+func (v prefixValue) Type() string { return "prefix" }
+
+type addrPortValue struct {
+	value *netip.AddrPort
+}
+
+func (v addrPortValue) Set(s string) error {
+	addrPort, err := netip.ParseAddrPort(s)
+	if err != nil {
+		return fmt.Errorf("invalid IP:port address: '%s'", s)
+	}
+	*v.value = addrPort
+	return nil
+}
+
+func (v addrPortValue) String() string {
+	if v.value == nil || !v.value.IsValid() {
+		return ""
+	}
+	return v.value.String()
+}
+
+func (v addrPortValue) Type() string { return "ip:port" }
+
+// tcpAddrValue resolves host:port addresses for *net.TCPAddr fields when
+// they're set.
+type tcpAddrValue struct {
+	value **net.TCPAddr
+}
+
+func (v tcpAddrValue) Set(s string) error {
+	if err := validateHostPort(s); err != nil {
+		return err
+	}
+	addr, err := net.ResolveTCPAddr("tcp", s)
+	if err != nil {
+		return fmt.Errorf("couldn't resolve '%s': %v", s, err)
+	}
+	*v.value = addr
+	return nil
+}
+
+func (v tcpAddrValue) String() string {
+	if v.value == nil || *v.value == nil {
+		return ""
+	}
+	return (*v.value).String()
+}
+
+func (v tcpAddrValue) Type() string { return "host:port" }
+
+// udpAddrValue resolves host:port addresses for *net.UDPAddr fields when
+// they're set.
+type udpAddrValue struct {
+	value **net.UDPAddr
+}
+
+func (v udpAddrValue) Set(s string) error {
+	if err := validateHostPort(s); err != nil {
+		return err
+	}
+	addr, err := net.ResolveUDPAddr("udp", s)
+	if err != nil {
+		return fmt.Errorf("couldn't resolve '%s': %v", s, err)
+	}
+	*v.value = addr
+	return nil
+}
+
+func (v udpAddrValue) String() string {
+	if v.value == nil || *v.value == nil {
+		return ""
+	}
+	return (*v.value).String()
+}
+
+func (v udpAddrValue) Type() string { return "host:port" }
+
+// HostPort is an address like "example.com:80", "[::1]:443", or ":8080".
+// Setting it from a flag, the environment, or a config source checks that it
+// has a port between 0 and 65535, but doesn't resolve the host.
+type HostPort string
+
+// Set validates and sets the address.
+func (hp *HostPort) Set(s string) error {
+	if err := validateHostPort(s); err != nil {
+		return err
+	}
+	*hp = HostPort(s)
+	return nil
+}
+
+func (hp *HostPort) String() string { return string(*hp) }
+
+// Type names the type in usage output.
+func (hp *HostPort) Type() string { return "host:port" }
+
+// Host gets the host part of the address.
+func (hp HostPort) Host() string {
+	host, _, _ := net.SplitHostPort(string(hp))
+	return host
+}
+
+// Port gets the port part of the address.
+func (hp HostPort) Port() string {
+	_, port, _ := net.SplitHostPort(string(hp))
+	return port
+}
+
+// validateHostPort checks that s is a host:port address with a numeric port.
+func validateHostPort(s string) error {
+	_, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return fmt.Errorf("invalid host:port: %v", err)
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return fmt.Errorf("invalid port '%s' in '%s'", port, s)
+	}
+	return nil
+}
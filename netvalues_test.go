@@ -0,0 +1,124 @@
+package commandeer
+
+import (
+	"bytes"
+	"flag"
+	"net"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type netMain struct {
+	IP       net.IP
+	Mask     net.IPMask
+	Net      net.IPNet
+	IPs      []net.IP `flag:"ips"`
+	Addr     netip.Addr
+	Prefix   netip.Prefix
+	AddrPort netip.AddrPort
+	TCP      *net.TCPAddr
+	UDP      *net.UDPAddr
+	Listen   HostPort
+}
+
+func TestNetValues(t *testing.T) {
+	args := []string{
+		"--ip", "10.0.0.1",
+		"--mask", "255.255.255.0",
+		"--net", "192.168.0.0/16",
+		"--ips", "10.0.0.1,::1",
+		"--addr", "fe80::1",
+		"--prefix", "10.0.0.0/8",
+		"--addr-port", "127.0.0.1:8080",
+		"--tcp", "127.0.0.1:80",
+		"--udp", "[::1]:53",
+		"--listen", ":9000",
+	}
+	for name, newFlagger := range testFlaggers() {
+		t.Run(name, func(t *testing.T) {
+			mm := &netMain{}
+			err := LoadArgsEnv(newFlagger(), mm, args, "", nil)
+			if err != nil {
+				t.Fatalf("loading: %v", err)
+			}
+			if !mm.IP.Equal(net.IPv4(10, 0, 0, 1)) || mm.Mask.String() != "ffffff00" || mm.Net.String() != "192.168.0.0/16" {
+				t.Errorf("unexpected net values: %v %v %v", mm.IP, mm.Mask, mm.Net)
+			}
+			if len(mm.IPs) != 2 || !mm.IPs[1].Equal(net.IPv6loopback) {
+				t.Errorf("unexpected IPs: %v", mm.IPs)
+			}
+			if mm.Addr != netip.MustParseAddr("fe80::1") || mm.Prefix != netip.MustParsePrefix("10.0.0.0/8") || mm.AddrPort.Port() != 8080 {
+				t.Errorf("unexpected netip values: %v %v %v", mm.Addr, mm.Prefix, mm.AddrPort)
+			}
+			if mm.TCP == nil || mm.TCP.Port != 80 || mm.UDP == nil || mm.UDP.Port != 53 {
+				t.Errorf("unexpected addrs: %v %v", mm.TCP, mm.UDP)
+			}
+			if mm.Listen != ":9000" || mm.Listen.Port() != "9000" {
+				t.Errorf("unexpected listen address: %v", mm.Listen)
+			}
+		})
+	}
+}
+
+func TestNetValueErrors(t *testing.T) {
+	tests := []struct {
+		arg string
+		err string
+	}{
+		{arg: "-ip=10.0.0", err: "invalid IP address: '10.0.0'"},
+		{arg: "-mask=255.0", err: "invalid IP mask: '255.0'"},
+		{arg: "-net=10.0.0.1", err: "invalid CIDR address: '10.0.0.1'"},
+		{arg: "-ips=10.0.0.1,nope", err: "invalid IP address: 'nope'"},
+		{arg: "-addr=::g", err: "invalid IP address: '::g'"},
+		{arg: "-addr-port=127.0.0.1", err: "invalid IP:port address: '127.0.0.1'"},
+		{arg: "-tcp=localhost", err: "invalid host:port: address localhost: missing port in address"},
+		{arg: "-listen=example.com:http", err: "invalid port 'http' in 'example.com:http'"},
+		{arg: "-listen=example.com:70000", err: "invalid port '70000' in 'example.com:70000'"},
+	}
+	for _, tst := range tests {
+		t.Run(tst.arg, func(t *testing.T) {
+			fs := flag.NewFlagSet("", flag.ContinueOnError)
+			fs.SetOutput(&bytes.Buffer{})
+			err := LoadArgsEnv(fs, &netMain{}, []string{tst.arg}, "", nil)
+			if err == nil || !strings.HasSuffix(err.Error(), tst.err) {
+				t.Errorf("expected error ending with '%s', got: %v", tst.err, err)
+			}
+		})
+	}
+
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	mm := &netMain{IPs: []net.IP{net.IPv4(1, 2, 3, 4)}, Listen: "localhost:80"}
+	err := Flags(fs, mm)
+	if err != nil {
+		t.Fatalf("setting up flags: %v", err)
+	}
+	defaults := map[string]string{"ips": "[1.2.3.4]", "listen": "localhost:80", "addr": "", "tcp": ""}
+	got := make(map[string]string)
+	for name := range defaults {
+		got[name] = fs.Lookup(name).DefValue
+	}
+	if !reflect.DeepEqual(got, defaults) {
+		t.Errorf("unexpected defaults: %v", got)
+	}
+}
+
+func TestRepeatedIPs(t *testing.T) {
+	exp := []net.IP{net.ParseIP("1.1.1.1"), net.ParseIP("2.2.2.2"), net.ParseIP("3.3.3.3")}
+	args := []string{"--ips", "1.1.1.1", "--ips", "2.2.2.2,3.3.3.3"}
+	env := map[string]string{"NET_IPS": "4.4.4.4"}
+	for name, newFlagger := range testFlaggers() {
+		t.Run(name, func(t *testing.T) {
+			mm := &netMain{IPs: []net.IP{net.ParseIP("5.5.5.5")}}
+			// a source means args are parsed twice
+			err := New(WithFlagSet(newFlagger()), WithArgs(args), WithEnv("NET_"), WithEnvLookup(envMap(env)), WithSources(SourceFunc(func(interface{}) error { return nil }))).Load(mm)
+			if err != nil {
+				t.Fatalf("loading: %v", err)
+			}
+			if !reflect.DeepEqual(mm.IPs, exp) {
+				t.Errorf("unexpected IPs: %v", mm.IPs)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package commandeer
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+)
+
+// Defaulter may be implemented by a struct passed to Parse to set its default
+// values.
+type Defaulter interface {
+	Defaults()
+}
+
+// Parse allocates a T, calls its Defaults method if it implements Defaulter,
+// then sets up flags for it and loads it from args and any other configured
+// sources (see Commandeer.Load). T must be a struct type. Go's type parameters
+// can't express that, so this can't be checked at compile time, and Parse
+// returns an error for other types before doing anything else.
+func Parse[T any](args []string, opts ...Option) (*T, error) {
+	if typ := reflect.TypeOf((*T)(nil)).Elem(); typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("commandeer.Parse: type parameter must be a struct type, but is %v", typ)
+	}
+	main := new(T)
+	if defaulter, ok := interface{}(main).(Defaulter); ok {
+		defaulter.Defaults()
+	}
+	opts = append(opts[:len(opts):len(opts)], WithArgs(args))
+	err := New(opts...).Load(main)
+	if err != nil {
+		return nil, err
+	}
+	return main, nil
+}
+
+// MustParse is like Parse, but it exits if there is an error, printing it to
+// stderr. It is meant to be called from main, e.g.
+//
+//	cfg := commandeer.MustParse[myapp.Config](os.Args[1:])
+func MustParse[T any](args []string, opts ...Option) *T {
+	main, err := Parse[T](args, append([]Option{WithExit(os.Exit)}, opts...)...)
+	if err != nil {
+		panic(err) // only reachable if WithExit was overridden
+	}
+	return main
+}
@@ -0,0 +1,70 @@
+package commandeer
+
+import (
+	"flag"
+	"strings"
+	"testing"
+)
+
+type parseConfig struct {
+	Host string
+	Port int
+}
+
+func (c *parseConfig) Defaults() {
+	c.Host, c.Port = "localhost", 8080
+}
+
+func TestParse(t *testing.T) {
+	fs := flag.NewFlagSet("parse", flag.ContinueOnError)
+	cfg, err := Parse[parseConfig]([]string{"-port", "9000"}, WithFlagSet(fs))
+	if err != nil {
+		t.Fatalf("parsing: %v", err)
+	}
+	if cfg.Host != "localhost" || cfg.Port != 9000 {
+		t.Errorf("unexpected config: %+v", cfg)
+	}
+	if f := fs.Lookup("port"); f.DefValue != "8080" {
+		t.Errorf("defaults should be used as flag defaults, got: %s", f.DefValue)
+	}
+
+	_, err = Parse[int](nil, WithFlagSet(flag.NewFlagSet("parse", flag.ContinueOnError)))
+	if err == nil || !strings.Contains(err.Error(), "commandeer.Parse: type parameter must be a struct type, but is int") {
+		t.Errorf("expected error for non-struct type, got: %v", err)
+	}
+}
+
+func TestParseKeepsOptions(t *testing.T) {
+	fs := flag.NewFlagSet("parse", flag.ContinueOnError)
+	opts := make([]Option, 1, 2)
+	opts[0] = WithFlagSet(fs)
+	spare := opts[:2]
+	spare[1] = WithNaming(SnakeCase)
+	_, err := Parse[parseConfig]([]string{"-port", "1"}, opts...)
+	if err != nil {
+		t.Fatalf("parsing: %v", err)
+	}
+	c := &Commandeer{}
+	spare[1](c)
+	if c.naming == nil {
+		t.Errorf("Parse overwrote the caller's spare option capacity")
+	}
+}
+
+func TestMustParse(t *testing.T) {
+	code := -1
+	exit := WithExit(func(c int) { code = c })
+	cfg := MustParse[parseConfig]([]string{"-host", "example.com"}, WithFlagSet(flag.NewFlagSet("parse", flag.ContinueOnError)), exit)
+	if cfg.Host != "example.com" || code != -1 {
+		t.Errorf("unexpected config: %+v, exit code %d", cfg, code)
+	}
+
+	defer func() {
+		if recover() == nil || code != 2 {
+			t.Errorf("expected exit with code 2 and panic, got exit code %d", code)
+		}
+	}()
+	fs := flag.NewFlagSet("parse", flag.ContinueOnError)
+	fs.SetOutput(&strings.Builder{})
+	MustParse[parseConfig]([]string{"-nope"}, WithFlagSet(fs), WithOutput(&strings.Builder{}, &strings.Builder{}), exit)
+}
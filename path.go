@@ -0,0 +1,206 @@
+package commandeer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// Path is a file system path. A Path field's flag expands a leading "~" to the
+// user's home directory and environment variables like "$HOME" in its value,
+// and a relative path from a config source which has a directory (like
+// JSONFile) is resolved against that directory rather than the working
+// directory. Use the "path" tag to check the path after it's loaded, or to get
+// the same behavior for a string field.
+type Path string
+
+var pathType = reflect.TypeOf(Path(""))
+
+// pathOptions are parsed from a "path" tag, e.g. path:"file,exists".
+type pathOptions struct {
+	// kind is "file", "dir", or empty for either.
+	kind     string
+	exists   bool
+	create   bool
+	readable bool
+	writable bool
+}
+
+func parsePathTag(tag string) (pathOptions, error) {
+	var opts pathOptions
+	for _, opt := range strings.Split(tag, ",") {
+		switch strings.TrimSpace(opt) {
+		case "":
+		case "file", "dir":
+			opts.kind = strings.TrimSpace(opt)
+		case "exists":
+			opts.exists = true
+		case "create":
+			opts.create = true
+		case "readable":
+			opts.readable = true
+		case "writable":
+			opts.writable = true
+		default:
+			return opts, fmt.Errorf("unknown path option '%s'", opt)
+		}
+	}
+	return opts, nil
+}
+
+// pathValue is the Value for a Path field, or a string field with the "path"
+// tag. set records whether it has been set, since defaults are expanded when
+// they're checked instead.
+type pathValue struct {
+	name  string
+	value reflect.Value
+	opts  pathOptions
+	fTr   *flagTracker
+	set   bool
+}
+
+func (v *pathValue) Set(s string) error {
+	path := v.fTr.expandPath(s)
+	if base := v.fTr.root().pathBase; path != "" && !filepath.IsAbs(path) && base != "" {
+		path = filepath.Join(base, path)
+	}
+	v.value.SetString(path)
+	v.set = true
+	return nil
+}
+
+func (v *pathValue) String() string {
+	if v == nil || !v.value.IsValid() {
+		return ""
+	}
+	return v.value.String()
+}
+
+func (v *pathValue) Type() string {
+	if v != nil && v.opts.kind != "" {
+		return v.opts.kind
+	}
+	return "path"
+}
+
+// check expands the path if it's still the default, then checks it against
+// the options, creating it if the "create" option was given. Empty paths
+// aren't checked.
+func (v *pathValue) check() error {
+	if !v.set {
+		v.value.SetString(v.fTr.expandPath(v.value.String()))
+	}
+	path := v.value.String()
+	if path == "" {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) && v.opts.create {
+		info, err = v.create(path)
+	}
+	if os.IsNotExist(err) {
+		if v.opts.exists {
+			return fmt.Errorf("%s doesn't exist", path)
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	switch {
+	case v.opts.kind == "file" && info.IsDir():
+		return fmt.Errorf("%s is a directory, not a file", path)
+	case v.opts.kind == "dir" && !info.IsDir():
+		return fmt.Errorf("%s is not a directory", path)
+	}
+	if v.opts.readable {
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("%s isn't readable: %v", path, err)
+		}
+		f.Close()
+	}
+	if v.opts.writable {
+		if err := checkWritable(path, info.IsDir()); err != nil {
+			return fmt.Errorf("%s isn't writable: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// create makes a missing directory (or a file, if the kind is "file") along
+// with its parents.
+func (v *pathValue) create(path string) (os.FileInfo, error) {
+	if v.opts.kind != "file" {
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return nil, err
+		}
+		return os.Stat(path)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	return os.Stat(path)
+}
+
+// checkWritable checks that a file can be opened for writing (without
+// truncating it), or that a file can be created in a directory.
+func checkWritable(path string, dir bool) error {
+	if !dir {
+		f, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		return f.Close()
+	}
+	f, err := os.CreateTemp(path, ".commandeer-")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// expandPath expands a leading "~" to the home directory and environment
+// variables (looked up the same way as for flags) in path.
+func (fTr *flagTracker) expandPath(path string) string {
+	path = os.Expand(path, func(key string) string {
+		val, _ := fTr.getenv(key)
+		return val
+	})
+	if path == "~" || strings.HasPrefix(path, "~/") || strings.HasPrefix(path, "~"+string(filepath.Separator)) {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = home + path[1:]
+		}
+	}
+	return path
+}
+
+// checkPaths checks every path flag once everything has been loaded, skipping
+// those of implementations which weren't selected.
+func (fTr *flagTracker) checkPaths() error {
+	for _, t := range fTr.trackers(false) {
+		for _, path := range t.paths {
+			if err := path.check(); err != nil {
+				return fmt.Errorf("%s: %v", path.name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// sourceDir gets the directory relative paths from source are resolved
+// against, if it has one.
+func sourceDir(source Source) string {
+	if source, ok := source.(interface{ Dir() string }); ok {
+		return source.Dir()
+	}
+	return ""
+}
@@ -0,0 +1,113 @@
+package commandeer
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type pathMain struct {
+	Config  Path
+	Data    string `path:"dir,create"`
+	Schema  string `path:"file,exists,readable"`
+	Cache   Path   `path:"dir"`
+	Scratch string
+}
+
+func TestPaths(t *testing.T) {
+	dir := t.TempDir()
+	confDir := filepath.Join(dir, "conf")
+	err := os.MkdirAll(confDir, 0755)
+	if err != nil {
+		t.Fatalf("making dir: %v", err)
+	}
+	err = os.WriteFile(filepath.Join(confDir, "schema.sql"), []byte("create table t;"), 0644)
+	if err != nil {
+		t.Fatalf("writing schema: %v", err)
+	}
+	conf := filepath.Join(confDir, "app.json")
+	err = os.WriteFile(conf, []byte(`{"data": "data", "schema": "schema.sql", "scratch": "tmp"}`), 0644)
+	if err != nil {
+		t.Fatalf("writing config: %v", err)
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Skipf("no home directory: %v", err)
+	}
+
+	mm := &pathMain{Cache: "~/does-not-matter"}
+	err = New(
+		WithFlagSet(flag.NewFlagSet("", flag.ContinueOnError)),
+		WithArgs([]string{"-config", "$APP_ROOT/app.json"}),
+		WithEnv("APP_"),
+		WithEnvLookup(envMap(map[string]string{"APP_ROOT": dir})),
+		WithSources(JSONFile(conf)),
+	).Load(mm)
+	if err != nil {
+		t.Fatalf("loading: %v", err)
+	}
+	if mm.Config != Path(filepath.Join(dir, "app.json")) {
+		t.Errorf("unexpected config: %s", mm.Config)
+	}
+	if mm.Data != filepath.Join(confDir, "data") || mm.Schema != filepath.Join(confDir, "schema.sql") {
+		t.Errorf("expected paths relative to the config file, got: %s %s", mm.Data, mm.Schema)
+	}
+	if info, err := os.Stat(mm.Data); err != nil || !info.IsDir() {
+		t.Errorf("expected data dir to be created: %v", err)
+	}
+	if mm.Scratch != "tmp" {
+		t.Errorf("expected untagged string to be left alone, got: %s", mm.Scratch)
+	}
+	if mm.Cache != Path(filepath.Join(home, "does-not-matter")) {
+		t.Errorf("expected default to be expanded, got: %s", mm.Cache)
+	}
+
+	mm = &pathMain{}
+	err = New(
+		WithFlagSet(flag.NewFlagSet("", flag.ContinueOnError)),
+		WithArgs([]string{"-schema", "schema.sql"}),
+		WithSources(JSONFile(conf)),
+	).Load(mm)
+	if err == nil || !strings.Contains(err.Error(), "checking paths: schema: schema.sql doesn't exist") {
+		t.Errorf("expected args to be relative to the working directory, got: %v", err)
+	}
+}
+
+func TestPathErrors(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	err := os.WriteFile(file, nil, 0644)
+	if err != nil {
+		t.Fatalf("writing file: %v", err)
+	}
+	tests := []struct {
+		args []string
+		err  string
+	}{
+		{args: []string{"-schema", filepath.Join(dir, "missing")}, err: "schema: " + filepath.Join(dir, "missing") + " doesn't exist"},
+		{args: []string{"-schema", dir}, err: "schema: " + dir + " is a directory, not a file"},
+		{args: []string{"-cache", file}, err: "cache: " + file + " is not a directory"},
+		{args: []string{"-data", filepath.Join(file, "sub")}, err: "data: "},
+	}
+	for _, tst := range tests {
+		err := New(WithFlagSet(flag.NewFlagSet("", flag.ContinueOnError)), WithArgs(tst.args)).Load(&pathMain{})
+		if err == nil || !strings.Contains(err.Error(), tst.err) {
+			t.Errorf("%v: expected error containing '%s', got: %v", tst.args, tst.err, err)
+		}
+	}
+
+	err = Flags(flag.NewFlagSet("", flag.ContinueOnError), &struct {
+		Out string `path:"file,huge"`
+	}{})
+	if err == nil || !strings.Contains(err.Error(), "unknown path option 'huge' for 'out'") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	err = Flags(flag.NewFlagSet("", flag.ContinueOnError), &struct {
+		Out int `path:"file"`
+	}{})
+	if err == nil || !strings.Contains(err.Error(), "path tag on non-string field 'out'") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -63,11 +63,26 @@ func TestNames(t *testing.T) {
 		"auint32",
 		"auint8",
 		"ipmask",
+		"no-a-bool",
 		"subthing.a-bool",
+		"subthing.no-a-bool",
 		"subthing.recursion.b-bool",
+		"subthing.recursion.no-b-bool",
 		"thing",
 	}
 	if !reflect.DeepEqual(expect, flagNames) {
 		t.Fatalf("expected %v but got %v", expect, flagNames)
 	}
 }
+
+func TestUsageInstalled(t *testing.T) {
+	m := test.NewMyMain()
+	flags := &compflag.FlagSet{pflag.NewFlagSet("tstusage", pflag.ContinueOnError)}
+	err := commandeer.Flags(flags, m)
+	if err != nil {
+		t.Fatalf("getting flags for MyMain: %v", err)
+	}
+	if flags.Usage == nil {
+		t.Fatalf("expected grouped usage func to be installed")
+	}
+}
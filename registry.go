@@ -0,0 +1,277 @@
+package commandeer
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// registeredType holds the functions passed to RegisterType, converted to work
+// on reflect.Values.
+type registeredType struct {
+	parse  func(string) (reflect.Value, error)
+	format func(reflect.Value) string
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[reflect.Type]registeredType)
+)
+
+// RegisterType makes fields of type T (and slices of T, and maps from strings
+// to T) usable as flags, for types which can't be given a Set or UnmarshalText
+// method because they're defined in another package. parse converts text from
+// the command line, environment, or a config source to a T, and format does
+// the opposite for usage output. Registered types take precedence over
+// commandeer's built in handling of a type. It's usually called from an init
+// func, e.g.
+//
+//	commandeer.RegisterType(regexp.Compile, (*regexp.Regexp).String)
+func RegisterType[T any](parse func(string) (T, error), format func(T) string) {
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[typ] = registeredType{
+		parse: func(s string) (reflect.Value, error) {
+			t, err := parse(s)
+			return reflect.ValueOf(&t).Elem(), err
+		},
+		format: func(v reflect.Value) string {
+			t, _ := v.Interface().(T)
+			return format(t)
+		},
+	}
+}
+
+// lookupType gets the registered functions for typ.
+func lookupType(typ reflect.Type) (registeredType, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	rt, ok := registry[typ]
+	return rt, ok
+}
+
+// elemType gets the functions for a type used as the value of a map, which
+// may be registered or a basic kind.
+func elemType(typ reflect.Type) (registeredType, bool) {
+	if rt, ok := lookupType(typ); ok {
+		return rt, true
+	}
+	var parse func(string) (interface{}, error)
+	switch typ.Kind() {
+	case reflect.String:
+		parse = func(s string) (interface{}, error) { return s, nil }
+	case reflect.Bool:
+		parse = func(s string) (interface{}, error) { return parseBool(s) }
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		parse = func(s string) (interface{}, error) {
+			n, err := strconv.ParseInt(s, 0, typ.Bits())
+			return n, numError(err)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		parse = func(s string) (interface{}, error) {
+			n, err := strconv.ParseUint(s, 0, typ.Bits())
+			return n, numError(err)
+		}
+	case reflect.Float32, reflect.Float64:
+		parse = func(s string) (interface{}, error) {
+			f, err := strconv.ParseFloat(s, typ.Bits())
+			return f, numError(err)
+		}
+	default:
+		return registeredType{}, false
+	}
+	return registeredType{
+		parse: func(s string) (reflect.Value, error) {
+			v, err := parse(s)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			return reflect.ValueOf(v).Convert(typ), nil
+		},
+		format: func(v reflect.Value) string { return fmt.Sprint(v.Interface()) },
+	}, true
+}
+
+// registeredValueFor gets a Value for f if its type (or the type of its
+// elements) was registered with RegisterType. Maps from strings to basic
+// kinds are supported as well.
+func registeredValueFor(f reflect.Value) (Value, bool) {
+	typ := f.Type()
+	if rt, ok := lookupType(typ); ok {
+		return registeredValue{value: f, typ: rt}, true
+	}
+	switch typ.Kind() {
+	case reflect.Slice:
+		if rt, ok := lookupType(typ.Elem()); ok {
+			return registeredSliceValue{value: f, typ: rt}, true
+		}
+	case reflect.Map:
+		if typ.Key().Kind() != reflect.String {
+			return nil, false
+		}
+		if rt, ok := elemType(typ.Elem()); ok {
+			return registeredMapValue{value: f, typ: rt}, true
+		}
+	}
+	return nil, false
+}
+
+// registeredValue is the Value for a field of a registered type.
+type registeredValue struct {
+	value reflect.Value
+	typ   registeredType
+}
+
+func (v registeredValue) Set(s string) error {
+	t, err := v.typ.parse(s)
+	if err != nil {
+		return err
+	}
+	v.value.Set(t)
+	return nil
+}
+
+func (v registeredValue) String() string {
+	if !v.value.IsValid() || isNil(v.value) {
+		return ""
+	}
+	return v.typ.format(v.value)
+}
+
+func (v registeredValue) Type() string {
+	return valueTypeName(v.value.Type())
+}
+
+// registeredSliceValue is the Value for a slice of a registered type. Like
+// sliceValue, Set replaces the slice and Append adds to it.
+type registeredSliceValue struct {
+	value reflect.Value
+	typ   registeredType
+}
+
+func (v registeredSliceValue) Set(s string) error {
+	v.value.Set(reflect.MakeSlice(v.value.Type(), 0, 0))
+	return v.Append(s)
+}
+
+func (v registeredSliceValue) Append(s string) error {
+	if s == "" {
+		return nil
+	}
+	for _, elem := range strings.Split(s, ",") {
+		t, err := v.typ.parse(strings.TrimSpace(elem))
+		if err != nil {
+			return err
+		}
+		v.value.Set(reflect.Append(v.value, t))
+	}
+	return nil
+}
+
+func (v registeredSliceValue) String() string {
+	if !v.value.IsValid() {
+		return "[]"
+	}
+	elems := make([]string, v.value.Len())
+	for i := range elems {
+		if elem := v.value.Index(i); !isNil(elem) {
+			elems[i] = v.typ.format(elem)
+		}
+	}
+	return "[" + strings.Join(elems, ",") + "]"
+}
+
+func (v registeredSliceValue) Type() string {
+	return "[]" + valueTypeName(v.value.Type().Elem())
+}
+
+// registeredMapValue is the Value for a map from strings to a registered type
+// or basic kind, set from text like "a=1,b=2". Set replaces the map, while
+// Append and SetKey add to it.
+type registeredMapValue struct {
+	value reflect.Value
+	typ   registeredType
+}
+
+func (v registeredMapValue) Set(s string) error {
+	v.value.Set(reflect.MakeMap(v.value.Type()))
+	return v.Append(s)
+}
+
+func (v registeredMapValue) Append(s string) error {
+	if s == "" {
+		return nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		key, val, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("expected key=value, got '%s'", pair)
+		}
+		err := v.SetKey(strings.TrimSpace(key), strings.TrimSpace(val))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetKey sets a single entry in the map. Config sources use it for nested
+// keys, e.g. "labels.env" for the "env" entry of the "labels" map.
+func (v registeredMapValue) SetKey(key, val string) error {
+	t, err := v.typ.parse(val)
+	if err != nil {
+		return err
+	}
+	if v.value.IsNil() {
+		v.value.Set(reflect.MakeMap(v.value.Type()))
+	}
+	v.value.SetMapIndex(reflect.ValueOf(key).Convert(v.value.Type().Key()), t)
+	return nil
+}
+
+func (v registeredMapValue) String() string {
+	if !v.value.IsValid() {
+		return "[]"
+	}
+	pairs := make([]string, 0, v.value.Len())
+	iter := v.value.MapRange()
+	for iter.Next() {
+		val := ""
+		if !isNil(iter.Value()) {
+			val = v.typ.format(iter.Value())
+		}
+		pairs = append(pairs, iter.Key().String()+"="+val)
+	}
+	sort.Strings(pairs)
+	return "[" + strings.Join(pairs, ",") + "]"
+}
+
+func (v registeredMapValue) Type() string {
+	typ := v.value.Type()
+	return "map[" + valueTypeName(typ.Key()) + "]" + valueTypeName(typ.Elem())
+}
+
+// isNil reports whether v is a nil pointer, interface, etc.
+func isNil(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+		return v.IsNil()
+	}
+	return false
+}
+
+// valueTypeName names a type for usage output, using the name of the type a
+// pointer points to (e.g. "level" for *level).
+func valueTypeName(typ reflect.Type) string {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if name := typ.Name(); name != "" {
+		return name
+	}
+	return typ.String()
+}
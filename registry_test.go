@@ -0,0 +1,130 @@
+package commandeer
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// logLevel stands in for a type from another package which can't be given
+// flag methods.
+type logLevel int
+
+var logLevels = []string{"debug", "info", "warn"}
+
+func parseLogLevel(s string) (logLevel, error) {
+	for i, name := range logLevels {
+		if s == name {
+			return logLevel(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level '%s'", s)
+}
+
+func (l logLevel) name() string { return logLevels[l] }
+
+func init() {
+	RegisterType(parseLogLevel, logLevel.name)
+}
+
+// registerType registers a type for the duration of a test, so that types
+// like *regexp.Regexp which commandeer also handles itself go back to that
+// for other tests.
+func registerType[T any](t *testing.T, parse func(string) (T, error), format func(T) string) {
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	prev, registered := lookupType(typ)
+	RegisterType(parse, format)
+	t.Cleanup(func() {
+		registryMu.Lock()
+		defer registryMu.Unlock()
+		if registered {
+			registry[typ] = prev
+		} else {
+			delete(registry, typ)
+		}
+	})
+}
+
+type registryMain struct {
+	Level   logLevel
+	Levels  []logLevel
+	Modules map[string]logLevel
+	Limits  map[string]int
+	Match   *regexp.Regexp
+}
+
+func TestRegisterType(t *testing.T) {
+	registerType(t, regexp.Compile, (*regexp.Regexp).String)
+	for name, newFlagger := range testFlaggers() {
+		t.Run(name, func(t *testing.T) {
+			mm := &registryMain{Level: 1}
+			err := New(
+				WithFlagSet(newFlagger()),
+				WithArgs([]string{"--levels", "debug,warn", "--match", "^a+$"}),
+				WithEnv("REG_"),
+				WithEnvLookup(envMap(map[string]string{"REG_LIMITS": "a=1,b=2"})),
+			).Load(mm)
+			if err != nil {
+				t.Fatalf("loading: %v", err)
+			}
+			if mm.Level != 1 || !reflect.DeepEqual(mm.Levels, []logLevel{0, 2}) || mm.Match.String() != "^a+$" {
+				t.Errorf("unexpected values: %+v", mm)
+			}
+			if !reflect.DeepEqual(mm.Limits, map[string]int{"a": 1, "b": 2}) {
+				t.Errorf("unexpected limits: %v", mm.Limits)
+			}
+		})
+	}
+
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.SetOutput(&bytes.Buffer{})
+	err := LoadArgsEnv(fs, &registryMain{}, []string{"-level", "loud"}, "", nil)
+	if err == nil || !strings.Contains(err.Error(), "unknown log level 'loud'") {
+		t.Errorf("expected parse error, got: %v", err)
+	}
+	if typ := fs.Lookup("modules").Value.(Value).Type(); typ != "map[string]logLevel" {
+		t.Errorf("unexpected type name: %s", typ)
+	}
+}
+
+func TestRegisterTypeSource(t *testing.T) {
+	path := t.TempDir() + "/config.json"
+	err := os.WriteFile(path, []byte(`{"level": "warn", "levels": ["info"], "modules": {"db": "debug", "http": "warn"}}`), 0600)
+	if err != nil {
+		t.Fatalf("writing config: %v", err)
+	}
+	mm := &registryMain{}
+	err = New(WithFlagSet(NewGNUFlagSet("", flag.ContinueOnError)), WithArgs([]string{"--modules", "http=info", "--modules", "ui=debug"}), WithSources(JSONFile(path))).Load(mm)
+	if err != nil {
+		t.Fatalf("loading: %v", err)
+	}
+	if mm.Level != 2 || !reflect.DeepEqual(mm.Levels, []logLevel{1}) {
+		t.Errorf("unexpected values: %+v", mm)
+	}
+	// args replace the whole map, and repeated flags add to it
+	if !reflect.DeepEqual(mm.Modules, map[string]logLevel{"http": 1, "ui": 0}) {
+		t.Errorf("unexpected modules: %v", mm.Modules)
+	}
+}
+
+func TestRegisterTypeSourceMap(t *testing.T) {
+	path := t.TempDir() + "/config.json"
+	err := os.WriteFile(path, []byte(`{"modules": {"db": "debug", "http": "warn"}}`), 0600)
+	if err != nil {
+		t.Fatalf("writing config: %v", err)
+	}
+	mm := &registryMain{Modules: map[string]logLevel{"ui": 1}}
+	err = New(WithFlagSet(flag.NewFlagSet("", flag.ContinueOnError)), WithArgs(nil), WithSources(JSONFile(path))).Load(mm)
+	if err != nil {
+		t.Fatalf("loading: %v", err)
+	}
+	// entries from sources are added to the defaults
+	if !reflect.DeepEqual(mm.Modules, map[string]logLevel{"db": 0, "http": 2, "ui": 1}) {
+		t.Errorf("unexpected modules: %v", mm.Modules)
+	}
+}
@@ -0,0 +1,130 @@
+package commandeer
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// mask replaces secret values wherever they would be printed.
+const mask = "********"
+
+// Secret is a string which is masked when printed, so that passwords and
+// tokens don't end up in usage output, error messages, or logged configs. Use
+// string(s) to get the actual value. Fields of other types can also be made
+// secret with the `secret:"true"` tag, which masks their flags' values.
+type Secret string
+
+// String returns a mask rather than the secret (or an empty string if the
+// secret is empty).
+func (s Secret) String() string {
+	return maskString(string(s))
+}
+
+// GoString masks the secret when formatted with %#v.
+func (s Secret) GoString() string {
+	return "commandeer.Secret(" + strconv.Quote(s.String()) + ")"
+}
+
+func maskString(s string) string {
+	if s == "" {
+		return ""
+	}
+	return mask
+}
+
+// isSecret reports whether a field should have its value masked.
+func isSecret(field reflect.StructField) bool {
+	if field.Type == reflect.TypeOf(Secret("")) {
+		return true
+	}
+	secret, _ := strconv.ParseBool(field.Tag.Get("secret"))
+	return secret
+}
+
+// secretValue is a Value for secret strings which masks the string
+// representation of the value.
+type secretValue struct {
+	value *string
+}
+
+func (s secretValue) Set(val string) error {
+	*s.value = val
+	return nil
+}
+
+func (s secretValue) String() string {
+	if s.value == nil {
+		return ""
+	}
+	return maskString(*s.value)
+}
+
+func (s secretValue) Type() string {
+	return "string"
+}
+
+// maskedValue wraps the Value of a secret field which isn't a string, masking
+// its string representation and the values it can't be set to.
+type maskedValue struct {
+	Value
+	fTr *flagTracker
+}
+
+// Set records values which can't be set so that errors from the flag set,
+// which quote them, can be masked, and masks them in its own errors.
+func (m maskedValue) Set(s string) error {
+	err := m.Value.Set(s)
+	if err != nil {
+		m.fTr.hideSecret(s)
+		return m.fTr.maskErr(err)
+	}
+	return nil
+}
+
+func (m maskedValue) String() string {
+	if m.Value == nil {
+		return ""
+	}
+	return maskString(m.Value.String())
+}
+
+// IsBoolFlag passes through to the wrapped Value, so that secret bools and
+// counts don't need a value on the command line.
+func (m maskedValue) IsBoolFlag() bool {
+	bf, ok := m.Value.(interface{ IsBoolFlag() bool })
+	return ok && bf.IsBoolFlag()
+}
+
+func (m maskedValue) bareValue() string {
+	if bare, ok := m.Value.(interface{ bareValue() string }); ok {
+		return bare.bareValue()
+	}
+	return ""
+}
+
+// hideSecret records a secret value which couldn't be set, or some input
+// containing secrets, so that maskErr masks it.
+func (fTr *flagTracker) hideSecret(s string) {
+	if s == "" {
+		return
+	}
+	root := fTr.root()
+	root.hidden = append(root.hidden, s)
+}
+
+// maskErr masks the values recorded by hideSecret in err's message.
+func (fTr *flagTracker) maskErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	for _, s := range fTr.root().hidden {
+		msg = strings.ReplaceAll(msg, s, mask)
+	}
+	if msg == err.Error() {
+		return err
+	}
+	return errors.New(msg)
+}
@@ -0,0 +1,124 @@
+package commandeer
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type secretMain struct {
+	User     string
+	Password Secret
+	Token    string `secret:"true"`
+	Pin      int    `secret:"true"`
+}
+
+func TestSecretString(t *testing.T) {
+	mm := secretMain{User: "bob", Password: "hunter2", Token: "abc"}
+	for _, format := range []string{"%v", "%+v", "%#v", "%s"} {
+		out := fmt.Sprintf(format, mm)
+		if strings.Contains(out, "hunter2") {
+			t.Errorf("secret leaked with format %s: %s", format, out)
+		}
+	}
+	if Secret("").String() != "" {
+		t.Errorf("empty secret should print as empty")
+	}
+}
+
+func TestSecretFlags(t *testing.T) {
+	fs := flag.NewFlagSet("secrets", flag.ContinueOnError)
+	buf := &bytes.Buffer{}
+	fs.SetOutput(buf)
+	mm := &secretMain{Password: "hunter2", Token: "abc", Pin: 1234}
+	mustSetenv(t, "SEC_TOKEN", "fromenv")
+	defer os.Unsetenv("SEC_TOKEN")
+	err := LoadArgsEnv(fs, mm, []string{"-password", "swordfish", "-pin", "4321"}, "SEC_", nil)
+	if err != nil {
+		t.Fatalf("loading args env: %v", err)
+	}
+	if mm.Password != "swordfish" || mm.Token != "fromenv" || mm.Pin != 4321 {
+		t.Errorf("secrets weren't set: %s %s %d", string(mm.Password), mm.Token, mm.Pin)
+	}
+	for _, name := range []string{"password", "token", "pin"} {
+		f := fs.Lookup(name)
+		if f.DefValue != mask || f.Value.String() != mask {
+			t.Errorf("%s not masked: %s %s", name, f.DefValue, f.Value.String())
+		}
+	}
+
+	fs.Usage()
+	if strings.Contains(buf.String(), "hunter2") || strings.Contains(buf.String(), "1234") || strings.Contains(buf.String(), "4321") || strings.Contains(buf.String(), "abc") {
+		t.Errorf("secret leaked in usage:\n%s", buf.String())
+	}
+
+	mustSetenv(t, "SEC_PIN", "notanumber")
+	defer os.Unsetenv("SEC_PIN")
+	fs = flag.NewFlagSet("secrets", flag.ContinueOnError)
+	err = LoadArgsEnv(fs, &secretMain{}, []string{}, "SEC_", nil)
+	if err == nil {
+		t.Fatalf("expected error loading bad pin")
+	}
+	if strings.Contains(err.Error(), "notanumber") {
+		t.Errorf("secret leaked in error: %v", err)
+	}
+}
+
+type credential struct {
+	User string
+	Pin  int `secret:"true"`
+}
+
+type vault interface {
+	Open() error
+}
+
+type plainVault struct {
+	Key int `secret:"true"`
+}
+
+func (v *plainVault) Open() error { return nil }
+
+func init() {
+	RegisterImpl[vault]("plain", &plainVault{})
+}
+
+type secretErrMain struct {
+	Pin   int `secret:"true"`
+	Creds []credential
+	Vault vault
+}
+
+func TestSecretErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(path, []byte(`{"pin": "hunter2"}`), 0600)
+	if err != nil {
+		t.Fatalf("writing config: %v", err)
+	}
+	for name, newFlagger := range testFlaggers() {
+		t.Run(name, func(t *testing.T) {
+			for _, opts := range [][]Option{
+				{WithArgs([]string{"--pin", "hunter2"})},
+				{WithArgs([]string{"--creds", "user=a,pin=hunter2"})},
+				{WithArgs([]string{"--creds", "pin=hunter2,user=a,nope=1"})},
+				{WithArgs([]string{"--creds.0.pin=hunter2"})},
+				{WithArgs([]string{"--vault=plain", "--vault.key=hunter2"})},
+				{WithArgs(nil), WithSources(JSONFile(path))},
+			} {
+				fs := newFlagger()
+				if setter, ok := fs.(interface{ SetOutput(io.Writer) }); ok {
+					setter.SetOutput(&bytes.Buffer{})
+				}
+				err := New(append(opts, WithFlagSet(fs))...).Load(&secretErrMain{})
+				if err == nil || strings.Contains(err.Error(), "hunter2") || !strings.Contains(err.Error(), mask) {
+					t.Errorf("expected an error with the secret masked, got: %v", err)
+				}
+			}
+		})
+	}
+}
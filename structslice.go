@@ -0,0 +1,507 @@
+package commandeer
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// maxStructSliceIndex limits how far an indexed flag can grow a slice of
+// structs, so that a typo like "upstream.10000000.host" doesn't allocate a
+// huge slice.
+const maxStructSliceIndex = 1 << 16
+
+var (
+	flagValueType       = reflect.TypeOf((*flag.Value)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// isStructSlice reports whether typ is a slice of structs (or pointers to
+// them) whose fields should get flags. Structs which can be set from text
+// themselves, like time.Time, are left out.
+func isStructSlice(typ reflect.Type) bool {
+	if typ.Kind() != reflect.Slice {
+		return false
+	}
+	elem := typ.Elem()
+	if elem.Kind() == reflect.Ptr {
+		elem = elem.Elem()
+	}
+	if elem.Kind() != reflect.Struct {
+		return false
+	}
+	ptr := reflect.PtrTo(elem)
+	return !ptr.Implements(flagValueType) && !ptr.Implements(textUnmarshalerType)
+}
+
+// structSliceValue is the Value for a slice of structs. It's set from one of:
+//
+//   - a JSON array of objects, which replaces the slice
+//   - an index and a field, like "0.host=a", which sets a field of an
+//     element (adding elements up to the index if necessary)
+//   - fields like "host=a,port=80", which add an element
+//
+// The first element added in each pass over the args or environment replaces
+// the slice rather than adding to it. Each element's fields are set through
+// flags on a GNUFlagSet of their own, so they can be of any type a field
+// usually can.
+type structSliceValue struct {
+	name  string
+	value reflect.Value
+	fTr   *flagTracker
+	fresh bool
+
+	// fields are the flag names of an element's fields, in order, and
+	// envKeys are their environment variable names (without a prefix or
+	// index). proto has the flags of a zero element, for looking up a
+	// field's kind.
+	fields  []string
+	envKeys map[string]string
+	types   []string
+	proto   *GNUFlagSet
+	// secret is set if any of an element's fields are secret, in which
+	// case values which can't be set are masked in errors.
+	secret bool
+
+	// elems holds the flags of the elements of the slice which have been
+	// used so far, in order.
+	elems []*structElem
+}
+
+// structElem is an element of a slice of structs. Its flags are bound to a
+// struct of its own, which is copied into the slice by store, so that they
+// stay valid when the slice grows.
+type structElem struct {
+	ptr reflect.Value
+	gnu *GNUFlagSet
+	sub *flagTracker
+}
+
+func newStructSliceValue(fTr *flagTracker, f reflect.Value, name string) (*structSliceValue, error) {
+	v := &structSliceValue{name: name, value: f, fTr: fTr, fresh: true}
+	e, err := v.newElem(reflect.Zero(f.Type().Elem()), -1)
+	if err != nil {
+		return nil, err
+	}
+	v.envKeys, v.proto = e.sub.envKeys, e.gnu
+	for _, info := range e.sub.infos {
+		v.secret = v.secret || info.secret
+	}
+	for _, field := range e.gnu.Flags() {
+		v.fields = append(v.fields, field)
+		v.types = append(v.types, field+"="+e.gnu.Lookup(field).Value.Type())
+	}
+	return v, nil
+}
+
+// structType gets the type of struct in the slice.
+func (v *structSliceValue) structType() reflect.Type {
+	elem := v.value.Type().Elem()
+	if elem.Kind() == reflect.Ptr {
+		return elem.Elem()
+	}
+	return elem
+}
+
+// newElem sets up flags for the fields of a copy of the element e (or the
+// struct it points to) at index i. The names of its paths and files include
+// the index so that errors from checking and opening them say which element
+// they're for.
+func (v *structSliceValue) newElem(e reflect.Value, i int) (*structElem, error) {
+	ptr := reflect.New(v.structType())
+	if e.Kind() == reflect.Ptr {
+		if !e.IsNil() {
+			ptr = e
+		}
+	} else {
+		ptr.Elem().Set(e)
+	}
+	gnu := NewGNUFlagSet(v.name, flag.ContinueOnError)
+	sub := v.fTr.subTracker(gnu)
+	sub.path, sub.envPath = v.fTr.fields[v.name]+"[]", ""
+	sub.elem = true
+	if err := setFlags(sub, ptr.Interface(), ""); err != nil {
+		return nil, err
+	}
+	prefix := v.name + v.fTr.separator + strconv.Itoa(i) + v.fTr.separator
+	for _, path := range sub.paths {
+		path.name = prefix + path.name
+	}
+	for _, file := range sub.files {
+		file.name = prefix + file.name
+	}
+	return &structElem{ptr: ptr, gnu: gnu, sub: sub}, nil
+}
+
+// elem gets the i'th element of the slice, adding elements if there aren't
+// that many.
+func (v *structSliceValue) elem(i int) (*structElem, error) {
+	for v.value.Len() <= i {
+		v.value.Set(reflect.Append(v.value, reflect.Zero(v.value.Type().Elem())))
+	}
+	if err := v.load(i + 1); err != nil {
+		return nil, err
+	}
+	return v.elems[i], nil
+}
+
+// load sets up flags for the first n elements of the slice if they don't
+// have them yet.
+func (v *structSliceValue) load(n int) error {
+	if len(v.elems) > v.value.Len() {
+		v.elems = v.elems[:v.value.Len()]
+	}
+	for i := len(v.elems); i < n; i++ {
+		e, err := v.newElem(v.value.Index(i), i)
+		if err != nil {
+			return err
+		}
+		v.elems = append(v.elems, e)
+	}
+	return nil
+}
+
+// store copies the elements' structs into the slice.
+func (v *structSliceValue) store() {
+	for i, e := range v.elems {
+		if i >= v.value.Len() {
+			return
+		}
+		if v.value.Type().Elem().Kind() == reflect.Ptr {
+			v.value.Index(i).Set(e.ptr)
+		} else {
+			v.value.Index(i).Set(e.ptr.Elem())
+		}
+	}
+}
+
+// reset empties the slice.
+func (v *structSliceValue) reset(n int) {
+	v.value.Set(reflect.MakeSlice(v.value.Type(), 0, n))
+	v.elems = nil
+}
+
+func (v *structSliceValue) Set(s string) error {
+	err := v.set(s)
+	v.store()
+	if err != nil && v.secret {
+		v.fTr.hideSecret(s)
+		return v.fTr.maskErr(err)
+	}
+	return err
+}
+
+func (v *structSliceValue) set(s string) error {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "[") {
+		v.fresh = false
+		return v.setJSON(s)
+	}
+	if index, key, val, ok := v.splitIndexed(s); ok {
+		v.fresh = false
+		return v.setField(index, key, val)
+	}
+	if v.fresh {
+		v.reset(0)
+		v.fresh = false
+	}
+	if s == "" {
+		return nil
+	}
+	return v.add(s)
+}
+
+// Append is like Set, except that it always adds an element rather than
+// replacing the slice. GNUFlagSet uses it for repeated flags.
+func (v *structSliceValue) Append(s string) error {
+	v.fresh = false
+	return v.Set(s)
+}
+
+// add adds an element with the fields given like "host=a,port=80". Values may
+// contain commas as long as what follows them doesn't look like another field,
+// and bool fields may be given without a value.
+func (v *structSliceValue) add(s string) error {
+	if err := v.load(v.value.Len()); err != nil {
+		return err
+	}
+	e, err := v.newElem(reflect.Zero(v.value.Type().Elem()), v.value.Len())
+	if err != nil {
+		return err
+	}
+	var pairs [][2]string
+	for _, part := range strings.Split(s, ",") {
+		key, val, ok := strings.Cut(part, "=")
+		key = strings.TrimSpace(key)
+		if f := e.gnu.Lookup(key); f != nil {
+			if !ok && f.noOptValue() == "" {
+				return fmt.Errorf("expected a value for '%s' in '%s'", key, s)
+			}
+			if !ok {
+				val = f.noOptValue()
+			}
+			pairs = append(pairs, [2]string{key, val})
+			continue
+		}
+		if len(pairs) == 0 || ok {
+			return fmt.Errorf("unknown field '%s', expected fields like %s", key, strings.Join(v.types, ","))
+		}
+		pairs[len(pairs)-1][1] += "," + part
+	}
+	for _, pair := range pairs {
+		if err := e.gnu.Set(pair[0], pair[1]); err != nil {
+			return fmt.Errorf("%s: %v", pair[0], err)
+		}
+	}
+	v.value.Set(reflect.Append(v.value, reflect.Zero(v.value.Type().Elem())))
+	v.elems = append(v.elems, e)
+	return nil
+}
+
+// splitIndexed splits text like "0.host=a" into its parts.
+func (v *structSliceValue) splitIndexed(s string) (index int, key, val string, ok bool) {
+	prefix, val, ok := strings.Cut(s, "=")
+	if !ok {
+		return 0, "", "", false
+	}
+	num, key, ok := strings.Cut(prefix, v.fTr.separator)
+	if !ok || num == "" || strings.TrimLeft(num, "0123456789") != "" {
+		return 0, "", "", false
+	}
+	index, err := strconv.Atoi(num)
+	if err != nil {
+		return 0, "", "", false
+	}
+	return index, key, val, true
+}
+
+// setField sets a field of the element at index.
+func (v *structSliceValue) setField(index int, key, val string) error {
+	name := v.name + v.fTr.separator + strconv.Itoa(index) + v.fTr.separator + key
+	if index > maxStructSliceIndex {
+		return fmt.Errorf("%s: index is too large", name)
+	}
+	e, err := v.elem(index)
+	if err != nil {
+		return err
+	}
+	if err := e.gnu.Set(key, val); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	return nil
+}
+
+// setJSON replaces the slice with the elements in a JSON array of objects.
+// Nested objects set the fields of nested structs.
+func (v *structSliceValue) setJSON(s string) error {
+	var objs []map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader([]byte(s)))
+	dec.UseNumber()
+	if err := dec.Decode(&objs); err != nil {
+		return fmt.Errorf("decoding JSON array of objects: %v", err)
+	}
+	v.reset(len(objs))
+	for i, obj := range objs {
+		e, err := v.elem(i)
+		if err != nil {
+			return err
+		}
+		err = setJSON(nil, obj, func(key []string, value string) error {
+			return e.gnu.Set(strings.Join(key, v.fTr.separator), value)
+		})
+		if err != nil {
+			return fmt.Errorf("element %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+func (v *structSliceValue) String() string {
+	if v == nil || !v.value.IsValid() {
+		return "[]"
+	}
+	if err := v.load(v.value.Len()); err != nil {
+		return "[]"
+	}
+	elems := make([]string, v.value.Len())
+	for i := range elems {
+		if isNil(v.value.Index(i)) {
+			continue
+		}
+		fields := make([]string, 0, len(v.fields))
+		for _, field := range v.fields {
+			if f := v.elems[i].gnu.Lookup(field); f != nil {
+				fields = append(fields, field+"="+f.Value.String())
+			}
+		}
+		elems[i] = strings.Join(fields, ",")
+	}
+	return "[" + strings.Join(elems, " ") + "]"
+}
+
+func (v *structSliceValue) Type() string {
+	if v == nil {
+		return ""
+	}
+	return strings.Join(v.types, ",")
+}
+
+// indexedEnv sets fields from environment variables like
+// "APP_UPSTREAM_0_HOST", starting from index 0 and stopping at the first index
+// past the end of the slice which has none.
+func (v *structSliceValue) indexedEnv(names []string, taken map[string]struct{}) error {
+	for i := 0; ; i++ {
+		found := false
+		for _, field := range v.fields {
+			for _, name := range names {
+				key := name + "_" + strconv.Itoa(i) + "_" + v.envKeys[field]
+				val, source, err := v.fTr.lookupEnv(key, false, taken)
+				if err != nil {
+					return err
+				}
+				if source == "" {
+					continue
+				}
+				found = true
+				err = v.setField(i, field, val)
+				v.store()
+				if err != nil {
+					return fmt.Errorf("from env %s: %v", source, err)
+				}
+				break
+			}
+		}
+		if !found && i >= v.value.Len() {
+			return nil
+		}
+	}
+}
+
+// validate calls the Validate method of each element, if it has one.
+func (v *structSliceValue) validate() error {
+	for i := 0; i < v.value.Len(); i++ {
+		e := v.value.Index(i)
+		if e.Kind() != reflect.Ptr {
+			e = e.Addr()
+		} else if e.IsNil() {
+			continue
+		}
+		if validator, ok := e.Interface().(interface{ Validate() error }); ok {
+			if err := validator.Validate(); err != nil {
+				return fmt.Errorf("%s%s%d: %v", v.name, v.fTr.separator, i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// indexedArgs rewrites indexed flags for slices of structs, like
+// "--upstream.0.host=a" or "--upstream.0.host a", to set the slice's own flag
+// instead ("--upstream=0.host=a"), since they can't all be defined ahead of
+// time.
+func (fTr *flagTracker) indexedArgs(args []string) []string {
+	fTr.rewritten = fTr.rewritten[:0]
+	out := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return append(out, args[i:]...)
+		}
+		name := strings.TrimLeft(arg, "-")
+		dashes := arg[:len(arg)-len(name)]
+		if dashes == "" || len(dashes) > 2 {
+			out = append(out, arg)
+			continue
+		}
+		name, val, hasVal := strings.Cut(name, "=")
+		v, field, ok := fTr.indexedFlag(name)
+		if !ok {
+			out = append(out, arg)
+			continue
+		}
+		if !hasVal {
+			if v.isBool(field) {
+				val = "true"
+			} else if i+1 < len(args) {
+				i++
+				val = args[i]
+			} else {
+				out = append(out, arg)
+				continue
+			}
+		}
+		out = append(out, dashes+v.name+"="+field+"="+val)
+		fTr.rewritten = append(fTr.rewritten, [3]string{v.name, field, val})
+	}
+	return out
+}
+
+// indexedErr rewrites an error from parsing args which were rewritten by
+// indexedArgs, which quotes the value and names the flag as they were
+// rewritten, to use the flag and value which were given instead.
+func (fTr *flagTracker) indexedErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	for _, r := range fTr.rewritten {
+		quoted := strconv.Quote(r[1] + "=" + r[2])
+		if !strings.Contains(msg, quoted) {
+			continue
+		}
+		msg = strings.Replace(msg, quoted, strconv.Quote(r[2]), 1)
+		msg = strings.Replace(msg, "-"+r[0], "-"+r[0]+fTr.separator+r[1], 1)
+		return errors.New(msg)
+	}
+	return err
+}
+
+// indexedFlag finds the slice of structs an indexed flag name like
+// "upstream.0.host" is for, and returns the rest of the name ("0.host").
+func (fTr *flagTracker) indexedFlag(name string) (*structSliceValue, string, bool) {
+	for _, t := range fTr.trackers(true) {
+		if t.elem {
+			continue
+		}
+		for _, v := range t.structSlices {
+			rest := strings.TrimPrefix(name, v.name+fTr.separator)
+			if rest == name {
+				continue
+			}
+			if _, _, _, ok := v.splitIndexed(rest + "="); ok {
+				return v, rest, true
+			}
+		}
+	}
+	return nil, "", false
+}
+
+// isBool reports whether the field an indexed name like "0.tls" refers to is
+// a bool.
+func (v *structSliceValue) isBool(indexed string) bool {
+	_, key, _, ok := v.splitIndexed(indexed + "=")
+	if !ok {
+		return false
+	}
+	f := v.proto.Lookup(key)
+	return f != nil && f.noOptValue() == "true"
+}
+
+// validateStructSlices validates the elements of every slice of structs once
+// everything has been loaded.
+func (fTr *flagTracker) validateStructSlices() error {
+	for _, t := range fTr.trackers(false) {
+		for _, v := range t.structSlices {
+			if err := v.validate(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package commandeer
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type upstream struct {
+	Host   string
+	Port   int
+	Weight int
+	TLS    bool `flag:"tls"`
+	Tags   []string
+}
+
+func (u *upstream) Validate() error {
+	if u.Host == "" {
+		return errors.New("host is required")
+	}
+	return nil
+}
+
+type upstreamMain struct {
+	Name      string
+	Upstreams []upstream `flag:"upstream"`
+	Backups   []*upstream
+}
+
+func TestStructSlices(t *testing.T) {
+	for name, newFlagger := range testFlaggers() {
+		t.Run(name, func(t *testing.T) {
+			mm := &upstreamMain{Upstreams: []upstream{{Host: "default"}}}
+			args := []string{
+				"--upstream", "host=a,port=80,tags=x,y",
+				"--upstream", "host=b,port=81,tls",
+				"--upstream.1.weight=5",
+				"--upstream.0.tls",
+				"--backups.0.host", "c",
+				"--name", "n",
+			}
+			err := LoadArgsEnv(newFlagger(), mm, args, "", nil)
+			if err != nil {
+				t.Fatalf("loading: %v", err)
+			}
+			want := []upstream{
+				{Host: "a", Port: 80, TLS: true, Tags: []string{"x", "y"}},
+				{Host: "b", Port: 81, Weight: 5, TLS: true},
+			}
+			if !reflect.DeepEqual(mm.Upstreams, want) {
+				t.Errorf("unexpected upstreams: %+v", mm.Upstreams)
+			}
+			if len(mm.Backups) != 1 || mm.Backups[0].Host != "c" || mm.Name != "n" {
+				t.Errorf("unexpected backups: %+v", mm.Backups)
+			}
+		})
+	}
+}
+
+func TestStructSlicesEnvAndSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(path, []byte(`{"upstream": [{"host": "a", "port": 80}, {"host": "b", "port": 81, "tags": ["p", "q"]}]}`), 0600)
+	if err != nil {
+		t.Fatalf("writing config: %v", err)
+	}
+	mm := &upstreamMain{}
+	err = New(
+		WithFlagSet(flag.NewFlagSet("", flag.ContinueOnError)),
+		WithArgs(nil),
+		WithEnv("APP_"),
+		WithEnvLookup(envMap(map[string]string{"APP_UPSTREAM_1_WEIGHT": "3", "APP_UPSTREAM_2_HOST": "c"})),
+		WithSources(JSONFile(path)),
+	).Load(mm)
+	if err != nil {
+		t.Fatalf("loading: %v", err)
+	}
+	want := []upstream{
+		{Host: "a", Port: 80},
+		{Host: "b", Port: 81, Weight: 3, Tags: []string{"p", "q"}},
+		{Host: "c"},
+	}
+	if !reflect.DeepEqual(mm.Upstreams, want) {
+		t.Errorf("unexpected upstreams: %+v", mm.Upstreams)
+	}
+
+	mm = &upstreamMain{}
+	err = New(
+		WithFlagSet(flag.NewFlagSet("", flag.ContinueOnError)),
+		WithArgs([]string{"-upstream", "host=d"}),
+		WithEnv("APP_"),
+		WithEnvLookup(envMap(map[string]string{"APP_UPSTREAM": `[{"host": "e"}]`})),
+		WithSources(JSONFile(path)),
+	).Load(mm)
+	if err != nil {
+		t.Fatalf("loading: %v", err)
+	}
+	if !reflect.DeepEqual(mm.Upstreams, []upstream{{Host: "d"}}) {
+		t.Errorf("expected args to replace the other sources, got: %+v", mm.Upstreams)
+	}
+}
+
+type mount struct {
+	Dir   string `path:"dir,exists"`
+	Out   io.Writer
+	Cache cache
+}
+
+func TestStructSliceHooks(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	for name, newFlagger := range testFlaggers() {
+		t.Run(name, func(t *testing.T) {
+			mm := &struct{ Mounts []mount }{}
+			args := []string{"--mounts", "dir=" + dir, "--mounts.0.out", out, "--mounts", "dir=" + dir, "--mounts", "dir=~", "--mounts.1.cache=none", "--mounts.1.cache.verbose=2"}
+			com := New(WithFlagSet(newFlagger()), WithArgs(args))
+			err := com.Load(mm)
+			if err != nil {
+				t.Fatalf("loading: %v", err)
+			}
+			home, _ := os.UserHomeDir()
+			if len(mm.Mounts) != 3 || mm.Mounts[0].Dir != dir || mm.Mounts[0].Out == nil || mm.Mounts[1].Out != nil || mm.Mounts[2].Dir != home {
+				t.Fatalf("unexpected mounts: %+v", mm.Mounts)
+			}
+			if c, ok := mm.Mounts[1].Cache.(*noCache); !ok || c.Verbose != 2 || mm.Mounts[0].Cache != nil {
+				t.Fatalf("unexpected mounts: %+v", mm.Mounts)
+			}
+			if _, err := io.WriteString(mm.Mounts[0].Out, "x"); err != nil {
+				t.Errorf("writing: %v", err)
+			}
+			if err := com.Close(); err != nil {
+				t.Fatalf("closing: %v", err)
+			}
+			if _, err := io.WriteString(mm.Mounts[0].Out, "x"); err == nil {
+				t.Errorf("expected out to be closed")
+			}
+
+			err = LoadArgsEnv(newFlagger(), &struct{ Mounts []mount }{}, []string{"--mounts", "dir=" + dir + ",out=" + filepath.Join(dir, "missing", "out")}, "", nil)
+			if err == nil || !strings.Contains(err.Error(), "opening files: mounts.0.out: open ") {
+				t.Errorf("unexpected error: %v", err)
+			}
+			err = LoadArgsEnv(newFlagger(), &struct{ Mounts []mount }{}, []string{"--mounts", "dir=" + dir, "--mounts.1.dir=/nonexistent"}, "", nil)
+			if err == nil || !strings.Contains(err.Error(), "checking paths: mounts.1.dir: /nonexistent doesn't exist") {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestStructSliceErrors(t *testing.T) {
+	tests := []struct {
+		args []string
+		err  string
+	}{
+		{args: []string{"-upstream", "host=a,nope=1"}, err: "unknown field 'nope', expected fields like host=string,port=int,weight=int,tls=bool,tags=strings"},
+		{args: []string{"-upstream", "host=a,port=x"}, err: "port: "},
+		{args: []string{"-upstream", "host=a,port"}, err: "expected a value for 'port'"},
+		{args: []string{"-upstream.0.port=x"}, err: `invalid value "x" for flag -upstream.0.port: upstream.0.port: `},
+		{args: []string{"-upstream.0.port=1"}, err: "validating: upstream.0: host is required"},
+		{args: []string{"-upstream.99999999.host=a"}, err: `invalid value "a" for flag -upstream.99999999.host: upstream.99999999.host: index is too large`},
+		{args: []string{"-upstream", `[{"host": 1`}, err: "decoding JSON array of objects"},
+	}
+	for _, tst := range tests {
+		fs := flag.NewFlagSet("", flag.ContinueOnError)
+		fs.SetOutput(&bytes.Buffer{})
+		err := LoadArgsEnv(fs, &upstreamMain{}, tst.args, "", nil)
+		if err == nil || !strings.Contains(err.Error(), tst.err) {
+			t.Errorf("%v: expected error containing '%s', got: %v", tst.args, tst.err, err)
+		}
+	}
+
+	for name, newFlagger := range testFlaggers() {
+		fs := newFlagger()
+		if setter, ok := fs.(interface{ SetOutput(io.Writer) }); ok {
+			setter.SetOutput(&bytes.Buffer{})
+		}
+		err := LoadArgsEnv(fs, &upstreamMain{}, []string{"--upstream.0.port", "x"}, "", nil)
+		if err == nil || !strings.Contains(err.Error(), `"x" for `) || !strings.Contains(err.Error(), "-upstream.0.port") || strings.Contains(err.Error(), "0.port=x") {
+			t.Errorf("%s: expected an error naming the indexed flag, got: %v", name, err)
+		}
+	}
+
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	err := Flags(fs, &upstreamMain{Upstreams: []upstream{{Host: "a", Port: 80}}})
+	if err != nil {
+		t.Fatalf("setting up flags: %v", err)
+	}
+	if def := fs.Lookup("upstream").DefValue; def != "[host=a,port=80,weight=0,tls=false,tags=[]]" {
+		t.Errorf("unexpected default: '%s'", def)
+	}
+}
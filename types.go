@@ -0,0 +1,202 @@
+package commandeer
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// This file has Values for standard library types which are common in
+// configuration but don't implement flag.Value themselves.
+
+// ptrValue adapts a Value for a T to a field of type *T. The field is only
+// replaced if the new value parses.
+type ptrValue[T any] struct {
+	value    **T
+	newValue func(*T) Value
+}
+
+func (v ptrValue[T]) Set(s string) error {
+	t := new(T)
+	err := v.newValue(t).Set(s)
+	if err != nil {
+		return err
+	}
+	*v.value = t
+	return nil
+}
+
+func (v ptrValue[T]) String() string {
+	if v.value == nil || *v.value == nil {
+		return ""
+	}
+	return v.newValue(*v.value).String()
+}
+
+func (v ptrValue[T]) Type() string { return v.newValue(new(T)).Type() }
+
+// timeValue parses times with a layout, which defaults to RFC 3339 (the format
+// time.Time uses for text).
+type timeValue struct {
+	value  *time.Time
+	layout string
+}
+
+func (v timeValue) Set(s string) error {
+	t, err := time.Parse(v.layout, s)
+	if err != nil {
+		return fmt.Errorf("expected a time like %s: %v", v.layout, err)
+	}
+	*v.value = t
+	return nil
+}
+
+func (v timeValue) String() string {
+	if v.value == nil {
+		return ""
+	}
+	return v.value.Format(v.layout)
+}
+
+func (v timeValue) Type() string { return "time" }
+
+type urlValue struct {
+	value *url.URL
+}
+
+func (v urlValue) Set(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return errors.Unwrap(err)
+	}
+	*v.value = *u
+	return nil
+}
+
+func (v urlValue) String() string {
+	if v.value == nil {
+		return ""
+	}
+	return v.value.String()
+}
+
+func (v urlValue) Type() string { return "url" }
+
+// regexpValue is for *regexp.Regexp fields, since regexps shouldn't be copied.
+type regexpValue struct {
+	value **regexp.Regexp
+}
+
+func (v regexpValue) Set(s string) error {
+	re, err := regexp.Compile(s)
+	if err != nil {
+		return err
+	}
+	*v.value = re
+	return nil
+}
+
+func (v regexpValue) String() string {
+	if v.value == nil || *v.value == nil {
+		return ""
+	}
+	return (*v.value).String()
+}
+
+func (v regexpValue) Type() string { return "regexp" }
+
+// fileModeValue parses file modes in octal, e.g. 644 or 0644.
+type fileModeValue struct {
+	value *os.FileMode
+}
+
+func (v fileModeValue) Set(s string) error {
+	n, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return numError(err)
+	}
+	*v.value = os.FileMode(n)
+	return nil
+}
+
+func (v fileModeValue) String() string {
+	if v.value == nil {
+		return "0"
+	}
+	return fmt.Sprintf("%#o", uint32(*v.value))
+}
+
+func (v fileModeValue) Type() string { return "mode" }
+
+// locationValue is for *time.Location fields. It points them at the loaded
+// location so that e.g. time.UTC compares equal.
+type locationValue struct {
+	value **time.Location
+}
+
+func (v locationValue) Set(s string) error {
+	loc, err := time.LoadLocation(s)
+	if err != nil {
+		return err
+	}
+	*v.value = loc
+	return nil
+}
+
+func (v locationValue) String() string {
+	if v.value == nil || *v.value == nil {
+		return ""
+	}
+	return (*v.value).String()
+}
+
+func (v locationValue) Type() string { return "location" }
+
+type bigIntValue struct {
+	value *big.Int
+}
+
+func (v bigIntValue) Set(s string) error {
+	n, ok := new(big.Int).SetString(s, 0)
+	if !ok {
+		return strconv.ErrSyntax
+	}
+	v.value.Set(n)
+	return nil
+}
+
+func (v bigIntValue) String() string {
+	if v.value == nil {
+		return "0"
+	}
+	return v.value.String()
+}
+
+func (v bigIntValue) Type() string { return "bigInt" }
+
+type bigFloatValue struct {
+	value *big.Float
+}
+
+func (v bigFloatValue) Set(s string) error {
+	f, _, err := big.ParseFloat(s, 0, 0, big.ToNearestEven)
+	if err != nil {
+		return strconv.ErrSyntax
+	}
+	v.value.Set(f)
+	return nil
+}
+
+func (v bigFloatValue) String() string {
+	if v.value == nil {
+		return "0"
+	}
+	return v.value.Text('g', -1)
+}
+
+func (v bigFloatValue) Type() string { return "bigFloat" }
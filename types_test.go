@@ -0,0 +1,99 @@
+package commandeer
+
+import (
+	"bytes"
+	"flag"
+	"math/big"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+type typesMain struct {
+	Since    time.Time `layout:"2006-01-02"`
+	Until    *time.Time
+	Endpoint url.URL
+	Proxy    *url.URL
+	Match    *regexp.Regexp
+	Mode     os.FileMode
+	Zone     *time.Location
+	Big      big.Int
+	Ratio    *big.Float
+}
+
+func TestStdlibTypes(t *testing.T) {
+	args := []string{
+		"--since", "2020-02-03",
+		"--until", "2021-01-02T03:04:05Z",
+		"--endpoint", "https://example.com/api?q=1",
+		"--proxy", "http://proxy:3128",
+		"--match", "^a+$",
+		"--mode", "0640",
+		"--zone", "UTC",
+		"--big", "123456789012345678901234567890",
+		"--ratio", "0.125",
+	}
+	for name, newFlagger := range testFlaggers() {
+		t.Run(name, func(t *testing.T) {
+			mm := &typesMain{Mode: 0600}
+			err := LoadArgsEnv(newFlagger(), mm, args, "", nil)
+			if err != nil {
+				t.Fatalf("loading: %v", err)
+			}
+			if !mm.Since.Equal(time.Date(2020, 2, 3, 0, 0, 0, 0, time.UTC)) {
+				t.Errorf("unexpected since: %v", mm.Since)
+			}
+			if mm.Until == nil || !mm.Until.Equal(time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)) {
+				t.Errorf("unexpected until: %v", mm.Until)
+			}
+			if mm.Endpoint.Host != "example.com" || mm.Endpoint.RawQuery != "q=1" || mm.Proxy == nil || mm.Proxy.Port() != "3128" {
+				t.Errorf("unexpected urls: %v %v", mm.Endpoint, mm.Proxy)
+			}
+			if mm.Match == nil || !mm.Match.MatchString("aaa") {
+				t.Errorf("unexpected regexp: %v", mm.Match)
+			}
+			if mm.Mode != 0640 {
+				t.Errorf("unexpected mode: %o", mm.Mode)
+			}
+			if mm.Zone != time.UTC {
+				t.Errorf("unexpected zone: %v", mm.Zone)
+			}
+			if mm.Big.String() != "123456789012345678901234567890" || mm.Ratio == nil || mm.Ratio.String() != "0.125" {
+				t.Errorf("unexpected big numbers: %v %v", &mm.Big, mm.Ratio)
+			}
+		})
+	}
+}
+
+func TestStdlibTypesUsageAndErrors(t *testing.T) {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.SetOutput(&bytes.Buffer{})
+	mm := &typesMain{Since: time.Date(2020, 2, 3, 0, 0, 0, 0, time.UTC), Mode: 0644}
+	err := Flags(fs, mm)
+	if err != nil {
+		t.Fatalf("setting up flags: %v", err)
+	}
+	for name, def := range map[string]string{"since": "2020-02-03", "mode": "0644", "until": "", "match": "", "big": "0"} {
+		if got := fs.Lookup(name).DefValue; got != def {
+			t.Errorf("unexpected default for %s: '%s'", name, got)
+		}
+	}
+	for _, arg := range []string{"-since=2020", "-mode=9", "-match=(", "-zone=Nowhere/Special", "-big=1.5"} {
+		err = fs.Parse([]string{arg})
+		if err == nil {
+			t.Errorf("expected error parsing %s", arg)
+		}
+	}
+	err = fs.Parse([]string{"-since=nope"})
+	if err == nil || !strings.Contains(err.Error(), `expected a time like 2006-01-02: parsing time "nope" as "2006-01-02": cannot parse "nope" as "2006"`) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	for name, typ := range map[string]string{"big": "bigInt", "ratio": "bigFloat"} {
+		if got := fs.Lookup(name).Value.(Value).Type(); got != typ {
+			t.Errorf("unexpected type for %s: %s", name, got)
+		}
+	}
+}
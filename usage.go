@@ -0,0 +1,513 @@
+package commandeer
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"text/template"
+)
+
+// flagInfo records what setFlags learned about a flag so that usage can be
+// printed in the order the fields were declared.
+type flagInfo struct {
+	name string
+	// prefix is the part of name which comes from parent structs.
+	prefix    string
+	short     string
+	help      string
+	zero      bool
+	secret    bool
+	negatable bool
+	noOpt     string
+	env       []string
+	listEnv   bool
+	group     *flagGroup
+}
+
+// flagGroup is a section of usage output. Each nested struct defines one, as
+// does each distinct "group" tag.
+type flagGroup struct {
+	name  string
+	desc  string
+	flags []*flagInfo
+}
+
+// track records a flag which was just set up for a field.
+func (fTr *flagTracker) track(field reflect.StructField, f reflect.Value, name, prefix, shorthand string) {
+	group := fTr.group
+	if groupName, ok := field.Tag.Lookup("group"); ok {
+		group = fTr.section(groupName)
+	}
+	info := &flagInfo{
+		name:      name,
+		prefix:    prefix,
+		short:     shorthand,
+		help:      flagHelp(field),
+		zero:      f.IsZero(),
+		secret:    isSecret(field),
+		negatable: field.Type.Kind() == reflect.Bool && field.Tag.Get("negatable") != "false",
+		noOpt:     field.Tag.Get("noopt"),
+		env:       fTr.envNames(&field, name),
+		listEnv:   fTr.listEnv(&field),
+		group:     group,
+	}
+	if f, ok := fTr.lookup(name); ok && info.noOpt != "" {
+		if _, ok := f.Value.(noOptValue); ok {
+			info.help = noOptHelp(info.help, name)
+		}
+	}
+	group.flags = append(group.flags, info)
+	fTr.infos[name] = info
+}
+
+// nestedGroup returns the group for the fields of a nested struct. The "group"
+// tag names it, and otherwise it is named after the field. Embedded structs
+// stay in the current group unless they are tagged. The "help" tag on the
+// struct field describes the group.
+func (fTr *flagTracker) nestedGroup(field reflect.StructField, embedded bool) *flagGroup {
+	group := fTr.group
+	if groupName, ok := field.Tag.Lookup("group"); ok {
+		group = fTr.section(groupName)
+	} else if !embedded {
+		name := field.Name
+		if fTr.group.name != "" {
+			name = fTr.group.name + "." + name
+		}
+		group = fTr.section(name)
+	}
+	if help := flagHelp(field); help != "" && group.name != "" {
+		group.desc = help
+	}
+	return group
+}
+
+// section gets the group with the given name, creating it if necessary.
+func (fTr *flagTracker) section(name string) *flagGroup {
+	for _, g := range fTr.groups {
+		if g.name == name {
+			return g
+		}
+	}
+	g := &flagGroup{name: name}
+	fTr.groups = append(fTr.groups, g)
+	return g
+}
+
+// Usage describes a command for the purposes of printing its usage. It is the
+// data passed to usage templates.
+type Usage struct {
+	// Name is the name of the flag set, usually the command name.
+	Name string
+	// Synopsis, Positionals, and Examples can't be derived from struct
+	// fields, but may be filled in by implementing UsageDescriber.
+	Synopsis    string
+	Positionals []UsagePositional
+	Examples    string
+	// GNU is true if flags are set up with a PFlagger, and should be
+	// printed with two dashes.
+	GNU bool
+	// Groups holds the flags in the order they were declared. The first
+	// group has no name, and holds flags which were not in a nested struct
+	// or "group" tagged field, as well as flags which commandeer didn't set
+	// up.
+	Groups []UsageGroup
+}
+
+// UsageGroup is a section of usage output.
+type UsageGroup struct {
+	Name        string
+	Description string
+	Flags       []UsageFlag
+}
+
+// UsageFlag describes a single flag.
+type UsageFlag struct {
+	Name  string
+	Short string
+	// Type is the name of the flag's type, or empty for booleans. It can
+	// be overridden by putting a name in back quotes in the help text (see
+	// flag.UnquoteUsage).
+	Type string
+	// Default is the flag's default value as text, or empty if it is the
+	// zero value for its type.
+	Default string
+	Help    string
+	// Env holds the names of the environment variables the flag is loaded
+	// from, if any. They're only listed if an environment prefix is used or
+	// the field has an "env" tag.
+	Env []string
+	// Negatable is true if the flag is a bool which can also be set to
+	// false with "no-" in front of the last part of its name (e.g.
+	// "db.no-cache"). prefix is the part before that.
+	Negatable bool
+	prefix    string
+	// NoOptDefault is the value the flag gets if it's given without one,
+	// or empty if it needs one.
+	NoOptDefault string
+}
+
+// UsagePositional describes a positional argument.
+type UsagePositional struct {
+	Name string
+	Help string
+}
+
+// UsageTemplater may be implemented by the struct passed to Flags or Run to
+// replace DefaultUsageTemplate.
+type UsageTemplater interface {
+	UsageTemplate() string
+}
+
+// UsageDescriber may be implemented by the struct passed to Flags or Run to
+// fill in parts of the Usage that can't be derived from its fields.
+type UsageDescriber interface {
+	DescribeUsage(u *Usage)
+}
+
+// DefaultUsageTemplate is the text/template used to print usage. It prints
+// flags in the same format as flag.PrintDefaults, but in sections. Along with
+// the standard functions, templates may use "flagNames" which formats a
+// UsageFlag's name and shorthand with the appropriate dashes, "indent" which
+// indents every line after the first of a string by the given prefix, "trim"
+// which trims surrounding space, and "cell" which escapes a string for a
+// Markdown table cell.
+const DefaultUsageTemplate = `{{if .Name}}Usage of {{.Name}}:{{else}}Usage:{{end}}
+{{- if .Synopsis}}
+  {{indent .Synopsis "  "}}{{end}}
+{{- range .Groups}}
+{{- if .Name}}
+
+{{.Name}}:
+{{- if .Description}}
+  {{indent .Description "  "}}{{end}}{{end}}
+{{- range .Flags}}
+  {{flagNames .}}{{if .Type}} {{.Type}}{{end}}
+{{- if .NoOptDefault}}[={{if eq .Type "string"}}{{printf "%q" .NoOptDefault}}{{else}}{{.NoOptDefault}}{{end}}]{{end}}
+    	{{indent .Help "    \t"}}
+{{- if .Env}}{{if .Help}} {{end}}[{{range $i, $e := .Env}}{{if $i}}, {{end}}${{$e}}{{end}}]{{end}}
+{{- if .Default}} (default {{if eq .Type "string"}}{{printf "%q" .Default}}{{else}}{{.Default}}{{end}}){{end}}
+{{- end}}{{end}}
+{{- if .Positionals}}
+
+Arguments:
+{{- range .Positionals}}
+  {{.Name}}
+    	{{indent .Help "    \t"}}{{end}}{{end}}
+{{- if .Examples}}
+
+Examples:
+  {{indent .Examples "  "}}{{end}}
+`
+
+// MarkdownUsageTemplate is a usage template which generates documentation in
+// Markdown, with a table of each group's flags along with their environment
+// variables and defaults. Use it with WithUsageTemplate and
+// Commandeer.WriteUsage to generate docs for a command, e.g. for its README.
+const MarkdownUsageTemplate = `# {{if .Name}}{{.Name}}{{else}}Usage{{end}}
+{{- if .Synopsis}}
+
+{{.Synopsis}}{{end}}
+{{- range .Groups}}{{if .Flags}}
+
+## {{if .Name}}{{.Name}}{{else}}Flags{{end}}
+{{- if .Description}}
+
+{{.Description}}{{end}}
+
+| Flag | Environment | Default | Description |
+| --- | --- | --- | --- |
+{{- range .Flags}}
+| ` + "`{{trim (flagNames .)}}{{if .Type}} {{.Type}}{{end}}`" + ` | {{range $i, $e := .Env}}{{if $i}}, {{end}}` + "`${{$e}}`" + `{{end}} | {{if .Default}}` + "`{{cell .Default}}`" + `{{end}} | {{cell .Help}} |
+{{- end}}{{end}}{{end}}
+{{- if .Positionals}}
+
+## Arguments
+{{range .Positionals}}
+- ` + "`{{.Name}}`" + `: {{.Help}}{{end}}{{end}}
+{{- if .Examples}}
+
+## Examples
+
+    {{indent .Examples "    "}}{{end}}
+`
+
+// usageFuncs returns the functions available to usage templates.
+func usageFuncs(gnu bool) template.FuncMap {
+	return template.FuncMap{
+		"flagNames": func(f UsageFlag) string {
+			name := f.Name
+			if f.Negatable && strings.HasPrefix(name, f.prefix) {
+				name = f.prefix + "[no-]" + name[len(f.prefix):]
+			}
+			switch {
+			case !gnu && f.Short != "":
+				return "-" + f.Short + ", -" + name
+			case !gnu:
+				return "-" + name
+			case f.Short != "":
+				return "-" + f.Short + ", --" + name
+			default:
+				return "    --" + name
+			}
+		},
+		"indent": func(s, prefix string) string {
+			return strings.ReplaceAll(s, "\n", "\n"+prefix)
+		},
+		"trim": strings.TrimSpace,
+		"cell": func(s string) string {
+			s = strings.ReplaceAll(s, "|", "\\|")
+			return strings.ReplaceAll(s, "\n", "<br>")
+		},
+	}
+}
+
+// usage builds a Usage from the flags which have been set up.
+func (fTr *flagTracker) usage() *Usage {
+	u := &Usage{
+		Name: fTr.name(),
+		GNU:  fTr.pflag,
+	}
+	tracked := make(map[string]struct{})
+	for _, g := range fTr.groups {
+		for _, info := range g.flags {
+			tracked[info.name] = struct{}{}
+		}
+	}
+	for alias := range fTr.aliases {
+		tracked[alias] = struct{}{}
+	}
+	for negation := range fTr.negations {
+		tracked[negation] = struct{}{}
+	}
+	for i, g := range fTr.groups {
+		if i > 0 && len(g.flags) == 0 {
+			continue
+		}
+		ug := UsageGroup{Name: g.name, Description: g.desc}
+		for _, info := range g.flags {
+			if uf, ok := fTr.usageFlag(info); ok {
+				ug.Flags = append(ug.Flags, uf)
+			}
+		}
+		if i == 0 {
+			for _, name := range fTr.names() {
+				if _, ok := tracked[name]; ok {
+					continue
+				}
+				if uf, ok := fTr.usageFlag(&flagInfo{name: name}); ok {
+					ug.Flags = append(ug.Flags, uf)
+				}
+			}
+		}
+		u.Groups = append(u.Groups, ug)
+	}
+	return u
+}
+
+// usageFlag describes a single flag for usage output.
+func (fTr *flagTracker) usageFlag(info *flagInfo) (UsageFlag, bool) {
+	f, ok := fTr.lookup(info.name)
+	if !ok {
+		return UsageFlag{}, false
+	}
+	uf := UsageFlag{
+		Name:         info.name,
+		Short:        info.short,
+		Negatable:    info.negatable,
+		prefix:       info.prefix,
+		NoOptDefault: info.noOpt,
+	}
+	if info.listEnv {
+		uf.Env = info.env
+	}
+	if info.group == nil {
+		if fTr.listEnv(nil) {
+			uf.Env = fTr.envNames(nil, info.name)
+		}
+	} else {
+		// f.Usage already has the environment variables appended
+		f.Usage = info.help
+	}
+	uf.Type, uf.Help = unquoteUsage(f)
+	if !info.zero && (info.group != nil || !isZeroDefault(f.DefValue)) {
+		uf.Default = f.DefValue
+		if info.secret {
+			uf.Default = mask
+		}
+	}
+	return uf, true
+}
+
+// isZeroDefault guesses whether the default value of a flag which wasn't set up
+// by commandeer is the zero value for its type.
+func isZeroDefault(defValue string) bool {
+	switch defValue {
+	case "", "0", "false", "[]", "0s", "<nil>":
+		return true
+	}
+	return false
+}
+
+// unquoteUsage is like flag.UnquoteUsage, but it also understands Values with
+// a Type method (such as pflag's).
+func unquoteUsage(f *flag.Flag) (typ, usage string) {
+	if v, ok := f.Value.(noOptValue); ok {
+		inner := *f
+		inner.Value = v.Value
+		return unquoteUsage(&inner)
+	}
+	typ, usage = flag.UnquoteUsage(f)
+	if typ != "value" || strings.Contains(f.Usage, "`") {
+		return typ, usage
+	}
+	if typer, ok := f.Value.(interface{ Type() string }); ok {
+		typ = typer.Type()
+	}
+	switch typ {
+	case "bool", "count":
+		typ = ""
+	case "float64":
+		typ = "float"
+	case "int64":
+		typ = "int"
+	case "uint64":
+		typ = "uint"
+	case "stringSlice":
+		typ = "strings"
+	}
+	return typ, usage
+}
+
+// installUsage sets the Usage func of the underlying flag set (if it has one)
+// to execute the usage template. main may implement UsageTemplater and
+// UsageDescriber.
+func (fTr *flagTracker) installUsage(main interface{}) error {
+	usage := fTr.field("Usage")
+	if !usage.IsValid() || !usage.CanSet() || usage.Type() != reflect.TypeOf(func() {}) {
+		return nil
+	}
+	tmpl, err := fTr.template(main)
+	if err != nil {
+		return err
+	}
+	usage.Set(reflect.ValueOf(func() {
+		out := fTr.output()
+		if err := fTr.writeUsage(out, tmpl, main); err != nil {
+			fmt.Fprintf(out, "\nexecuting usage template: %v\n", err)
+		}
+	}))
+	return nil
+}
+
+// template parses the usage template, which is the one set with
+// WithUsageTemplate, or the one main's UsageTemplate method returns, or
+// DefaultUsageTemplate.
+func (fTr *flagTracker) template(main interface{}) (*template.Template, error) {
+	text := DefaultUsageTemplate
+	if fTr.usageTemplate != "" {
+		text = fTr.usageTemplate
+	} else if templater, ok := main.(UsageTemplater); ok {
+		text = templater.UsageTemplate()
+	}
+	tmpl, err := template.New("usage").Funcs(usageFuncs(fTr.pflag)).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parsing usage template: %v", err)
+	}
+	return tmpl, nil
+}
+
+// writeUsage executes tmpl with the Usage for main.
+func (fTr *flagTracker) writeUsage(w io.Writer, tmpl *template.Template, main interface{}) error {
+	u := fTr.usage()
+	if describer, ok := main.(UsageDescriber); ok {
+		describer.DescribeUsage(u)
+	}
+	return tmpl.Execute(w, u)
+}
+
+// field reflectively gets the named field from the struct underlying the
+// flagger, returning the zero Value if there isn't one.
+func (fTr *flagTracker) field(name string) (field reflect.Value) {
+	defer func() {
+		if recover() != nil {
+			field = reflect.Value{} // nil embedded pointer
+		}
+	}()
+	v := reflect.ValueOf(fTr.flagger)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return reflect.Value{}
+	}
+	return v.Elem().FieldByName(name)
+}
+
+// output gets the writer usage should be printed to.
+func (fTr *flagTracker) output() io.Writer {
+	if fTr.out != nil {
+		return fTr.out
+	}
+	if outputter, ok := fTr.flagger.(interface{ Output() io.Writer }); ok {
+		return outputter.Output()
+	}
+	return os.Stderr
+}
+
+// name gets the name of the underlying flag set if it has one.
+func (fTr *flagTracker) name() string {
+	if namer, ok := fTr.flagger.(interface{ Name() string }); ok {
+		return namer.Name()
+	}
+	return ""
+}
+
+// names lists the names of all flags defined on the underlying flag set.
+func (fTr *flagTracker) names() []string {
+	switch flags := fTr.flagger.(type) {
+	case FlagNamer:
+		return flags.Flags()
+	case *flag.FlagSet:
+		return (&flagSet{flags}).Flags()
+	}
+	return nil
+}
+
+// lookup reflectively calls Lookup on the underlying flag implementation and
+// converts the result to a *flag.Flag. pflag.Flag has all the same fields, and
+// pflag.Value is a superset of flag.Value.
+func (fTr *flagTracker) lookup(name string) (*flag.Flag, bool) {
+	fv, ok := fTr.lookupFlag(name)
+	if !ok {
+		return nil, false
+	}
+	f := &flag.Flag{Name: name}
+	if usage := fv.FieldByName("Usage"); usage.Kind() == reflect.String {
+		f.Usage = usage.String()
+	}
+	if defValue := fv.FieldByName("DefValue"); defValue.Kind() == reflect.String {
+		f.DefValue = defValue.String()
+	}
+	value := fv.FieldByName("Value")
+	if !value.IsValid() || !value.CanInterface() {
+		return nil, false
+	}
+	if f.Value, ok = value.Interface().(flag.Value); !ok {
+		return nil, false
+	}
+	return f, true
+}
+
+// lookupFlag reflectively calls Lookup on the underlying flag implementation,
+// and returns the struct the result points to.
+func (fTr *flagTracker) lookupFlag(name string) (reflect.Value, bool) {
+	lookupMethod := reflect.ValueOf(fTr.flagger).MethodByName("Lookup")
+	if !lookupMethod.IsValid() {
+		return reflect.Value{}, false
+	}
+	out := lookupMethod.Call([]reflect.Value{reflect.ValueOf(name)})
+	if len(out) != 1 || out[0].Kind() != reflect.Ptr || out[0].IsNil() || out[0].Elem().Kind() != reflect.Struct {
+		return reflect.Value{}, false
+	}
+	return out[0].Elem(), true
+}
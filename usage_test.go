@@ -0,0 +1,254 @@
+package commandeer
+
+import (
+	"bytes"
+	"flag"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/spf13/pflag"
+)
+
+type groupedMain struct {
+	Zebra   string `help:"comes first anyway"`
+	Storage struct {
+		Path string `help:"where to store things"`
+		Size int
+	} `help:"Options for storing things."`
+	Verbose bool   `help:"talk more" short:"v"`
+	Apple   string `group:"Fruit" help:"a fruit"`
+	Nest    struct {
+		Deep struct {
+			Down int
+		}
+	} `group:"Nesting"`
+}
+
+func TestGroupedUsage(t *testing.T) {
+	mm := &groupedMain{Zebra: "z"}
+	mm.Storage.Size = 7
+	fs := flag.NewFlagSet("grouped", flag.ContinueOnError)
+	buf := &bytes.Buffer{}
+	fs.SetOutput(buf)
+	fs.Int("other", 0, "not from a struct")
+	err := Flags(fs, mm)
+	if err != nil {
+		t.Fatalf("calling Flags: %v", err)
+	}
+
+	fs.Usage()
+	expect := `Usage of grouped:
+  -zebra string
+    	comes first anyway (default "z")
+  -v, -[no-]verbose
+    	talk more
+  -other int
+    	not from a struct
+
+Storage:
+  Options for storing things.
+  -storage.path string
+    	where to store things
+  -storage.size int
+    	 (default 7)
+
+Fruit:
+  -apple string
+    	a fruit
+
+Nesting.Deep:
+  -nest.deep.down int
+    	
+`
+	if buf.String() != expect {
+		t.Fatalf("unexpected usage:\n%s\nexpected:\n%s", buf.String(), expect)
+	}
+}
+
+func TestGroupedUsagePflag(t *testing.T) {
+	fs := pflag.NewFlagSet("grouped", pflag.ContinueOnError)
+	fTr := newFlagTracker(fs)
+	err := setFlags(fTr, &groupedMain{}, "")
+	if err != nil {
+		t.Fatalf("setting flags: %v", err)
+	}
+	fTr.negate()
+
+	buf := &bytes.Buffer{}
+	tmpl := template.Must(template.New("").Funcs(usageFuncs(true)).Parse(DefaultUsageTemplate))
+	err = fTr.writeUsage(buf, tmpl, nil)
+	if err != nil {
+		t.Fatalf("writing usage: %v", err)
+	}
+	expect := `Usage:
+      --zebra string
+    	comes first anyway
+  -v, --[no-]verbose
+    	talk more
+
+Storage:
+  Options for storing things.
+      --storage.path string
+    	where to store things
+      --storage.size int
+    	
+
+Fruit:
+      --apple string
+    	a fruit
+
+Nesting.Deep:
+      --nest.deep.down int
+    	
+`
+	if buf.String() != expect {
+		t.Fatalf("unexpected usage:\n%s\nexpected:\n%s", buf.String(), expect)
+	}
+}
+
+type templatedMain struct {
+	Port    int    `help:"port to listen on"`
+	Host    string `help:"host to bind"`
+	Verbose bool
+}
+
+func (m *templatedMain) UsageTemplate() string {
+	return `{{.Synopsis}}
+{{range .Groups}}{{range .Flags}}{{.Name}}|{{.Type}}|{{.Default}}|{{.Help}}
+{{end}}{{end}}{{range .Positionals}}<{{.Name}}> {{.Help}}
+{{end}}{{.Examples}}`
+}
+
+func (m *templatedMain) DescribeUsage(u *Usage) {
+	u.Synopsis = "serve [flags] <dir>"
+	u.Positionals = []UsagePositional{{Name: "dir", Help: "directory to serve"}}
+	u.Examples = "serve -port 80 /var/www"
+}
+
+func (m *templatedMain) Run() error { return nil }
+
+func TestUsageTemplate(t *testing.T) {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	buf := &bytes.Buffer{}
+	fs.SetOutput(buf)
+	err := RunArgs(fs, &templatedMain{Port: 8080}, []string{"-h"})
+	if err == nil || !strings.Contains(err.Error(), flag.ErrHelp.Error()) {
+		t.Fatalf("expected help error, got: %v", err)
+	}
+	expect := `serve [flags] <dir>
+port|int|8080|port to listen on
+host|string||host to bind
+verbose|||
+<dir> directory to serve
+serve -port 80 /var/www`
+	if buf.String() != expect {
+		t.Fatalf("unexpected usage:\n%s\nexpected:\n%s", buf.String(), expect)
+	}
+}
+
+type badTemplateMain struct {
+	templatedMain
+}
+
+func (m *badTemplateMain) UsageTemplate() string { return "{{.Nope" }
+
+func TestBadUsageTemplate(t *testing.T) {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	err := Flags(fs, &badTemplateMain{})
+	if err == nil || !strings.Contains(err.Error(), "parsing usage template") {
+		t.Fatalf("expected template parsing error, got: %v", err)
+	}
+}
+
+func TestUsageEnv(t *testing.T) {
+	fs := flag.NewFlagSet("envy", flag.ContinueOnError)
+	buf := &bytes.Buffer{}
+	fs.SetOutput(buf)
+	mm := &groupedMain{}
+	err := LoadArgsEnv(&flagSet{fs}, mm, []string{}, "APP_", nil)
+	if err != nil {
+		t.Fatalf("loading args env: %v", err)
+	}
+	if f := fs.Lookup("storage.path"); f.Usage != "where to store things [$APP_STORAGE_PATH]" {
+		t.Errorf("unexpected usage text for storage.path: %s", f.Usage)
+	}
+	if f := fs.Lookup("storage.size"); f.Usage != "[$APP_STORAGE_SIZE]" {
+		t.Errorf("unexpected usage text for storage.size: %s", f.Usage)
+	}
+
+	fs.Usage()
+	if !strings.Contains(buf.String(), `
+  -zebra string
+    	comes first anyway [$APP_ZEBRA]
+`) {
+		t.Fatalf("expected env var in usage, got:\n%s", buf.String())
+	}
+}
+
+func TestUsageEnvNoPrefix(t *testing.T) {
+	type envTagged struct {
+		Zebra string `help:"comes first anyway"`
+		Port  int    `env:"PORT"`
+	}
+	fs := flag.NewFlagSet("envy", flag.ContinueOnError)
+	err := LoadArgsEnv(&flagSet{fs}, &envTagged{}, []string{}, "", nil)
+	if err != nil {
+		t.Fatalf("loading args env: %v", err)
+	}
+	if f := fs.Lookup("zebra"); f.Usage != "comes first anyway" {
+		t.Errorf("unexpected usage text for zebra: %s", f.Usage)
+	}
+	if f := fs.Lookup("port"); f.Usage != "[$PORT]" {
+		t.Errorf("unexpected usage text for port: %s", f.Usage)
+	}
+}
+
+func TestMarkdownUsage(t *testing.T) {
+	mm := &groupedMain{Zebra: "z|z"}
+	c := New(WithFlagSet(NewGNUFlagSet("grouped", flag.ContinueOnError)), WithEnv("APP_"), WithUsageTemplate(MarkdownUsageTemplate))
+	if err := c.WriteUsage(&bytes.Buffer{}); err == nil {
+		t.Fatalf("expected an error before flags are set up")
+	}
+	err := c.Flags(mm)
+	if err != nil {
+		t.Fatalf("calling Flags: %v", err)
+	}
+	buf := &bytes.Buffer{}
+	err = c.WriteUsage(buf)
+	if err != nil {
+		t.Fatalf("writing usage: %v", err)
+	}
+	exp := "# grouped\n" + `
+## Flags
+
+| Flag | Environment | Default | Description |
+| --- | --- | --- | --- |
+| ` + "`--zebra string` | `$APP_ZEBRA` | `z\\|z` | comes first anyway |" + `
+| ` + "`-v, --[no-]verbose` | `$APP_VERBOSE` |  | talk more |" + `
+
+## Storage
+
+Options for storing things.
+
+| Flag | Environment | Default | Description |
+| --- | --- | --- | --- |
+| ` + "`--storage.path string` | `$APP_STORAGE_PATH` |  | where to store things |" + `
+| ` + "`--storage.size int` | `$APP_STORAGE_SIZE` |  |  |" + `
+
+## Fruit
+
+| Flag | Environment | Default | Description |
+| --- | --- | --- | --- |
+| ` + "`--apple string` | `$APP_APPLE` |  | a fruit |" + `
+
+## Nesting.Deep
+
+| Flag | Environment | Default | Description |
+| --- | --- | --- | --- |
+| ` + "`--nest.deep.down int` | `$APP_NEST_DEEP_DOWN` |  |  |" + `
+`
+	if buf.String() != exp {
+		t.Fatalf("unexpected markdown usage:\n%s\nexpected:\n%s", buf.String(), exp)
+	}
+}
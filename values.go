@@ -0,0 +1,419 @@
+package commandeer
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// This file has Value implementations for the basic types, which are used by
+// GNUFlagSet, and by setFlags for types the stdlib flag package doesn't
+// support.
+
+type signed interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64
+}
+
+type unsigned interface {
+	~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64
+}
+
+// numError strips the function and input from strconv errors, since the flag
+// name and input are included in parsing errors anyway.
+func numError(err error) error {
+	var ne *strconv.NumError
+	if errors.As(err, &ne) {
+		return ne.Err
+	}
+	return err
+}
+
+// kindName gets the name of T's kind (e.g. "int8") for use as a Value's type.
+func kindName[T any]() string {
+	return reflect.TypeOf(*new(T)).Kind().String()
+}
+
+func bitSize[T any]() int {
+	return int(reflect.TypeOf(*new(T)).Size()) * 8
+}
+
+type stringValue struct {
+	value *string
+}
+
+func (v stringValue) Set(s string) error {
+	*v.value = s
+	return nil
+}
+
+func (v stringValue) String() string {
+	if v.value == nil {
+		return ""
+	}
+	return *v.value
+}
+
+func (v stringValue) Type() string { return "string" }
+
+type boolValue struct {
+	value *bool
+}
+
+func (v boolValue) Set(s string) error {
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return numError(err)
+	}
+	*v.value = b
+	return nil
+}
+
+func (v boolValue) String() string {
+	if v.value == nil {
+		return "false"
+	}
+	return strconv.FormatBool(*v.value)
+}
+
+func (v boolValue) Type() string { return "bool" }
+
+// IsBoolFlag means the flag doesn't need a value on the command line.
+func (v boolValue) IsBoolFlag() bool { return true }
+
+type intValue[T signed] struct {
+	value *T
+}
+
+func (v intValue[T]) Set(s string) error {
+	n, err := strconv.ParseInt(s, 0, bitSize[T]())
+	if err != nil {
+		return numError(err)
+	}
+	*v.value = T(n)
+	return nil
+}
+
+func (v intValue[T]) String() string {
+	if v.value == nil {
+		return "0"
+	}
+	return strconv.FormatInt(int64(*v.value), 10)
+}
+
+func (v intValue[T]) Type() string { return kindName[T]() }
+
+type uintValue[T unsigned] struct {
+	value *T
+}
+
+func (v uintValue[T]) Set(s string) error {
+	n, err := strconv.ParseUint(s, 0, bitSize[T]())
+	if err != nil {
+		return numError(err)
+	}
+	*v.value = T(n)
+	return nil
+}
+
+func (v uintValue[T]) String() string {
+	if v.value == nil {
+		return "0"
+	}
+	return strconv.FormatUint(uint64(*v.value), 10)
+}
+
+func (v uintValue[T]) Type() string { return kindName[T]() }
+
+type floatValue[T ~float32 | ~float64] struct {
+	value *T
+}
+
+func (v floatValue[T]) Set(s string) error {
+	f, err := strconv.ParseFloat(s, bitSize[T]())
+	if err != nil {
+		return numError(err)
+	}
+	*v.value = T(f)
+	return nil
+}
+
+func (v floatValue[T]) String() string {
+	if v.value == nil {
+		return "0"
+	}
+	return strconv.FormatFloat(float64(*v.value), 'g', -1, bitSize[T]())
+}
+
+func (v floatValue[T]) Type() string { return kindName[T]() }
+
+type durationValue struct {
+	value *time.Duration
+}
+
+func (v durationValue) Set(s string) error {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return errors.New("invalid duration")
+	}
+	*v.value = d
+	return nil
+}
+
+func (v durationValue) String() string {
+	if v.value == nil {
+		return "0s"
+	}
+	return v.value.String()
+}
+
+func (v durationValue) Type() string { return "duration" }
+
+// sliceValue is a Value for a slice of any type. Set replaces the slice with
+// the comma separated values given, while Append (used for repeated flags on
+// the command line by GNUFlagSet) adds to it.
+type sliceValue[T any] struct {
+	value  *[]T
+	parse  func(string) (T, error)
+	format func(T) string
+	typ    string
+}
+
+func (v sliceValue[T]) Set(s string) error {
+	*v.value = (*v.value)[:0:0]
+	return v.Append(s)
+}
+
+func (v sliceValue[T]) Append(s string) error {
+	if s == "" {
+		return nil
+	}
+	for _, elem := range strings.Split(s, ",") {
+		t, err := v.parse(strings.TrimSpace(elem))
+		if err != nil {
+			return err
+		}
+		*v.value = append(*v.value, t)
+	}
+	return nil
+}
+
+func (v sliceValue[T]) String() string {
+	if v.value == nil {
+		return "[]"
+	}
+	elems := make([]string, len(*v.value))
+	for i, t := range *v.value {
+		elems[i] = v.format(t)
+	}
+	return "[" + strings.Join(elems, ",") + "]"
+}
+
+func (v sliceValue[T]) Type() string { return v.typ }
+
+// repeatedSlice is a sliceValue which adds to the slice when its flag is
+// repeated on any flag set, rather than only on those which call Append. The
+// first Set after it's made fresh replaces the slice, and later ones add to
+// it.
+type repeatedSlice[T any] struct {
+	sliceValue[T]
+	fresh bool
+}
+
+func (v *repeatedSlice[T]) Set(s string) error {
+	if v.fresh {
+		v.fresh = false
+		return v.sliceValue.Set(s)
+	}
+	return v.sliceValue.Append(s)
+}
+
+func (v *repeatedSlice[T]) Append(s string) error {
+	v.fresh = false
+	return v.sliceValue.Append(s)
+}
+
+func (v *repeatedSlice[T]) reset() { v.fresh = true }
+
+func parseBool(s string) (bool, error) {
+	b, err := strconv.ParseBool(s)
+	return b, numError(err)
+}
+
+func parseInt(s string) (int, error) {
+	n, err := strconv.ParseInt(s, 0, strconv.IntSize)
+	return int(n), numError(err)
+}
+
+func parseUint(s string) (uint, error) {
+	n, err := strconv.ParseUint(s, 0, strconv.IntSize)
+	return uint(n), numError(err)
+}
+
+func parseIP(s string) (net.IP, error) {
+	ip := net.ParseIP(strings.TrimSpace(s))
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address: '%s'", s)
+	}
+	return ip, nil
+}
+
+type ipValue struct {
+	value *net.IP
+}
+
+func (v ipValue) Set(s string) error {
+	ip, err := parseIP(s)
+	if err != nil {
+		return err
+	}
+	*v.value = ip
+	return nil
+}
+
+func (v ipValue) String() string {
+	if v.value == nil || *v.value == nil {
+		return ""
+	}
+	return v.value.String()
+}
+
+func (v ipValue) Type() string { return "ip" }
+
+// parseIPMask parses a mask in either dotted decimal (255.255.255.0) or hex
+// (ffffff00) form.
+func parseIPMask(s string) (net.IPMask, error) {
+	s = strings.TrimSpace(s)
+	if ip := net.ParseIP(s); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			return net.IPMask(ip4), nil
+		}
+		return net.IPMask(ip), nil
+	}
+	mask, err := hex.DecodeString(s)
+	if err != nil || (len(mask) != net.IPv4len && len(mask) != net.IPv6len) {
+		return nil, fmt.Errorf("invalid IP mask: '%s'", s)
+	}
+	return net.IPMask(mask), nil
+}
+
+type ipMaskValue struct {
+	value *net.IPMask
+}
+
+func (v ipMaskValue) Set(s string) error {
+	mask, err := parseIPMask(s)
+	if err != nil {
+		return err
+	}
+	*v.value = mask
+	return nil
+}
+
+func (v ipMaskValue) String() string {
+	if v.value == nil || *v.value == nil {
+		return ""
+	}
+	return v.value.String()
+}
+
+func (v ipMaskValue) Type() string { return "ipMask" }
+
+type ipNetValue struct {
+	value *net.IPNet
+}
+
+func (v ipNetValue) Set(s string) error {
+	_, ipNet, err := net.ParseCIDR(strings.TrimSpace(s))
+	if err != nil {
+		return fmt.Errorf("invalid CIDR address: '%s'", s)
+	}
+	*v.value = *ipNet
+	return nil
+}
+
+func (v ipNetValue) String() string {
+	if v.value == nil || v.value.IP == nil {
+		return ""
+	}
+	return v.value.String()
+}
+
+func (v ipNetValue) Type() string { return "ipNet" }
+
+// countValue is the Value for a "count" tagged integer field. Each time the
+// flag is given without a value it's incremented, and it can also be set to a
+// number. The first increment after it's made fresh starts from the default,
+// or from zero if a number was given (e.g. from the environment), so that the
+// args override it.
+type countValue struct {
+	value reflect.Value
+	def   int64
+	fresh bool
+	set   bool
+	// bareTrue is set on flag sets without NoOptDefVal, which set a flag
+	// given without a value to "true", when args aren't rewritten to give
+	// it "+1" instead (see bareArgs). Otherwise "true" isn't a count.
+	bareTrue bool
+}
+
+func (c *countValue) Set(s string) error {
+	if s == "+1" || (s == "true" && c.bareTrue) {
+		n := c.count()
+		if c.fresh && c.set {
+			n = 0
+		} else if c.fresh {
+			n = c.def
+		}
+		c.fresh = false
+		return c.setCount(n + 1)
+	}
+	n, err := strconv.ParseInt(s, 0, 64)
+	if err != nil {
+		return numError(err)
+	}
+	c.fresh, c.set = false, true
+	return c.setCount(n)
+}
+
+func (c *countValue) setCount(n int64) error {
+	switch c.value.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if n < 0 || c.value.OverflowUint(uint64(n)) {
+			return strconv.ErrRange
+		}
+		c.value.SetUint(uint64(n))
+	default:
+		if c.value.OverflowInt(n) {
+			return strconv.ErrRange
+		}
+		c.value.SetInt(n)
+	}
+	return nil
+}
+
+func (c *countValue) count() int64 {
+	switch c.value.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(c.value.Uint())
+	}
+	return c.value.Int()
+}
+
+func (c *countValue) String() string {
+	if !c.value.IsValid() {
+		return "0"
+	}
+	return strconv.FormatInt(c.count(), 10)
+}
+
+func (c *countValue) Type() string { return "count" }
+
+// IsBoolFlag means the flag doesn't need a value on the command line.
+func (c *countValue) IsBoolFlag() bool { return true }
+
+func (c *countValue) bareValue() string { return "+1" }